@@ -4,10 +4,13 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -20,8 +23,13 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
+	}
+
 	// Define CLI flags
 	configPath := flag.String("config", "config.yaml", "Path to YAML configuration file")
+	debug := flag.Bool("debug", false, "Preview every response before it is sent to the SP")
 	showVersion := flag.Bool("version", false, "Show version and exit")
 	flag.Parse()
 
@@ -31,30 +39,75 @@ func main() {
 	}
 
 	// Load configuration from YAML file
-	cfg, err := config.LoadConfig(*configPath)
+	cfg, problems, err := config.Validate(*configPath)
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	if errs := config.Errors(problems); len(errs) > 0 {
+		fatalProblems("Invalid configuration", errs)
+	}
+
+	logger, err := cfg.Logging.NewLogger(os.Stderr)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("Failed to configure logging", err)
+	}
+	slog.SetDefault(logger)
+
+	// Check the keys and the settings the IDP interprets, reporting every
+	// problem at once
+	problems = append(problems, idp.Validate(cfg, time.Now())...)
+	for _, p := range problems {
+		if p.Warning {
+			slog.Warn("Configuration warning", "problem", p.String())
+		}
+	}
+	if errs := config.Errors(problems); len(errs) > 0 {
+		fatalProblems("Invalid configuration", errs)
+	}
+
+	if *debug {
+		cfg.Server.Debug = true
 	}
 
 	// Set default base URL if not provided
 	if cfg.Server.BaseURL == "" {
-		cfg.Server.BaseURL = fmt.Sprintf("http://%s:%d", cfg.Server.Host, cfg.Server.Port)
+		scheme := "http"
+		if cfg.Server.TLS.Enabled() {
+			scheme = "https"
+		}
+		cfg.Server.BaseURL = fmt.Sprintf("%s://%s:%d", scheme, cfg.Server.Host, cfg.Server.Port)
 	}
 
 	// Set default entity ID if not provided
 	if cfg.IDP.EntityID == "" {
-		cfg.IDP.EntityID = cfg.Server.BaseURL + "/metadata"
+		cfg.IDP.EntityID = strings.TrimSuffix(cfg.Server.BaseURL, "/") + "/metadata"
 	}
 
-	// Create IDP server
-	idpServer, err := idp.New(cfg)
+	// Create an IDP server for each tenant, or just one without tenants
+	idpServers, err := idp.NewTenants(cfg)
 	if err != nil {
-		log.Fatalf("Failed to create IDP server: %v", err)
+		fatal("Failed to create IDP server", err)
 	}
 
 	// Set up HTTP routes
 	mux := http.NewServeMux()
-	idpServer.RegisterRoutes(mux)
+	for _, idpServer := range idpServers {
+		idpServer.RegisterRoutes(mux)
+	}
+	if len(cfg.Tenants) > 0 {
+		mux.Handle("/{$}", idp.TenantsHandler(idpServers))
+	}
+	healthz, readyz := idp.HealthHandlers(idpServers)
+	if !servesRootHealth(idpServers) {
+		mux.Handle("/healthz", healthz)
+		mux.Handle("/readyz", readyz)
+	} else if len(cfg.Tenants) > 0 {
+		slog.Info("A tenant is served at the root, so /readyz only checks that tenant; use /readyz/tenants to check every tenant")
+	}
+	// The combined check stays available when a tenant holds /readyz
+	if len(cfg.Tenants) > 0 {
+		mux.Handle("/readyz/tenants", readyz)
+	}
 
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 
@@ -64,25 +117,72 @@ func main() {
 		Handler: mux,
 	}
 
+	if cfg.Server.TLS.Enabled() {
+		server.TLSConfig, err = cfg.Server.TLS.ServerTLSConfig(tlsHosts(cfg))
+		if err != nil {
+			fatal("Failed to configure TLS", err)
+		}
+		if cfg.Server.TLS.AutoGenerate {
+			slog.Info("Using auto-generated TLS certificate", "ca_file", cfg.Server.TLS.GetCACertPath())
+		}
+	}
+
+	// Optionally serve metrics on their own listener, away from the login routes
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled && cfg.Metrics.Address != "" {
+		metricsMux := http.NewServeMux()
+		for _, idpServer := range idpServers {
+			metricsPath := "/metrics"
+			if idpServer.Tenant() != "" {
+				metricsPath += "/" + idpServer.Tenant()
+			}
+			metricsMux.Handle(metricsPath, idpServer.MetricsHandler())
+		}
+		metricsServer = &http.Server{
+			Addr:    cfg.Metrics.Address,
+			Handler: metricsMux,
+		}
+		go func() {
+			slog.Info("Starting metrics server", "addr", cfg.Metrics.Address)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Metrics server failed", err)
+			}
+		}()
+	}
+
 	// Channel to listen for shutdown signals
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Starting SAML IDP server on %s", addr)
-		log.Printf("  Metadata URL: %s/metadata", cfg.Server.BaseURL)
-		log.Printf("  SSO URL: %s/sso", cfg.Server.BaseURL)
-		log.Printf("Press Ctrl+C to stop")
+		slog.Info("Starting SAML IDP server", "addr", addr)
+		for _, idpServer := range idpServers {
+			logger := slog.Default()
+			if idpServer.Tenant() != "" {
+				logger = logger.With("tenant", idpServer.Tenant())
+			}
+			logger.Info("Serving IDP",
+				"metadata_url", idpServer.GetIDP().MetadataURL.String(),
+				"sso_url", idpServer.GetIDP().SSOURL.String(),
+			)
+		}
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+		var err error
+		if server.TLSConfig != nil {
+			// The certificate is already in TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Server failed", err)
 		}
 	}()
 
 	// Wait for shutdown signal
 	<-shutdown
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
 
 	// Create a deadline for graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -90,8 +190,57 @@ func main() {
 
 	// Attempt graceful shutdown
 	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+		slog.Error("Server shutdown error", "err", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			slog.Error("Metrics server shutdown error", "err", err)
+		}
+	}
+
+	// Webhook deliveries still pending at the deadline are dropped
+	for _, idpServer := range idpServers {
+		if err := idpServer.Shutdown(ctx); err != nil {
+			slog.Error("Error closing IDP server", "tenant", idpServer.Tenant(), "err", err)
+		}
+	}
+
+	slog.Info("Server stopped")
+}
+
+// servesRootHealth reports whether a server already has /healthz and
+// /readyz at the root, i.e. it isn't under a base URL path or host name.
+func servesRootHealth(servers []*idp.Server) bool {
+	for _, server := range servers {
+		if server.ServesRootHealth() {
+			return true
+		}
 	}
+	return false
+}
+
+// tlsHosts returns the names a generated certificate should cover when none
+// are configured: the listen host and the host of each base URL.
+func tlsHosts(cfg *config.Config) []string {
+	hosts := []string{cfg.Server.Host}
+	for _, tenantCfg := range cfg.TenantConfigs() {
+		if u, err := url.Parse(tenantCfg.Server.BaseURL); err == nil && u.Hostname() != "" && !slices.Contains(hosts, u.Hostname()) {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+// fatalProblems logs each configuration problem and exits.
+func fatalProblems(msg string, problems []config.Problem) {
+	for _, p := range problems {
+		slog.Error(msg, "problem", p.String())
+	}
+	os.Exit(1)
+}
 
-	log.Println("Server stopped")
+// fatal logs an error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
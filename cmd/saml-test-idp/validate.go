@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/breakroom/saml-test-idp/internal/idp"
+)
+
+// validate runs the validate command, which prints every problem in a
+// config file, and returns the exit status: 1 if there are errors (or, with
+// -strict, warnings).
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: saml-test-idp validate [options] [config.yaml]")
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "config.yaml", "Path to YAML configuration file")
+	strict := flags.Bool("strict", false, "Fail on warnings as well as errors")
+	flags.Parse(args)
+	// Options may also follow the config path, as in validate config.yaml -strict
+	if flags.NArg() > 0 {
+		*configPath = flags.Arg(0)
+		flags.Parse(flags.Args()[1:])
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+		flags.Usage()
+		return 2
+	}
+
+	cfg, problems, err := config.Validate(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	problems = append(problems, idp.Validate(cfg, time.Now())...)
+	config.SortProblems(problems)
+
+	errors := 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if !p.Warning {
+			errors++
+		}
+	}
+	warnings := len(problems) - errors
+
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK\n", *configPath)
+		return 0
+	}
+	fmt.Printf("%s: %d error(s), %d warning(s)\n", *configPath, errors, warnings)
+	if errors > 0 || (*strict && warnings > 0) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// Attribute NameFormat URIs.
+const (
+	AttrNameFormatBasic       = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+	AttrNameFormatURI         = "urn:oasis:names:tc:SAML:2.0:attrname-format:uri"
+	AttrNameFormatUnspecified = "urn:oasis:names:tc:SAML:2.0:attrname-format:unspecified"
+)
+
+// AttrNameFormats maps friendly config names to attribute NameFormat URIs.
+var AttrNameFormats = map[string]string{
+	"basic":       AttrNameFormatBasic,
+	"uri":         AttrNameFormatURI,
+	"unspecified": AttrNameFormatUnspecified,
+}
+
+// AttrTypeNone is the type value that omits xsi:type from attribute values.
+const AttrTypeNone = "none"
+
+// AttributeDeclaration is the expanded form of a user attribute, written in
+// YAML as a map instead of a literal value:
+//
+//	employeeNumber:
+//	  name_format: uri
+//	  friendly_name: employeeNumber
+//	  type: xs:integer
+//	  value: 42
+type AttributeDeclaration struct {
+	Name         string
+	FriendlyName string
+	NameFormat   string
+	// Type is the xsi:type of each value, or empty to omit it.
+	Type string
+	// Values holds the rendered values; a nil entry is emitted as xsi:nil.
+	Values []*string
+}
+
+var attributeDeclarationKeys = map[string]bool{
+	"name_format":   true,
+	"friendly_name": true,
+	"type":          true,
+	"value":         true,
+	"values":        true,
+}
+
+// ParseAttributeDeclaration parses the map form of a user attribute.
+// The name format defaults to basic and the type to xs:string. Use value for
+// a single (possibly null) value or values for a list; omitting both sends
+// the attribute without any values.
+func ParseAttributeDeclaration(name string, decl map[string]interface{}) (*AttributeDeclaration, error) {
+	attr, err := parseAttributeDeclaration(name, decl)
+	if err != nil {
+		return nil, fmt.Errorf("attribute %s: %w", name, err)
+	}
+	return attr, nil
+}
+
+func parseAttributeDeclaration(name string, decl map[string]interface{}) (*AttributeDeclaration, error) {
+	keys := make([]string, 0, len(decl))
+	for key := range decl {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !attributeDeclarationKeys[key] {
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+	}
+
+	attr := &AttributeDeclaration{
+		Name:         name,
+		FriendlyName: cast.ToString(decl["friendly_name"]),
+		NameFormat:   AttrNameFormatBasic,
+		Type:         "xs:string",
+	}
+
+	if nameFormat := cast.ToString(decl["name_format"]); nameFormat != "" {
+		if uri, ok := AttrNameFormats[nameFormat]; ok {
+			attr.NameFormat = uri
+		} else if strings.HasPrefix(nameFormat, "urn:") {
+			attr.NameFormat = nameFormat
+		} else {
+			return nil, fmt.Errorf("unknown name_format %q (use %s or a URI)", nameFormat, strings.Join(sortedKeys(AttrNameFormats), ", "))
+		}
+	}
+
+	if typ, ok := decl["type"]; ok {
+		switch t := cast.ToString(typ); {
+		case t == AttrTypeNone:
+			attr.Type = ""
+		case strings.HasPrefix(t, "xs:"):
+			attr.Type = t
+		default:
+			return nil, fmt.Errorf("unknown type %q (use an xs: type or %q)", t, AttrTypeNone)
+		}
+	}
+
+	value, hasValue := decl["value"]
+	values, hasValues := decl["values"]
+	switch {
+	case hasValue && hasValues:
+		return nil, fmt.Errorf("use either value or values, not both")
+	case hasValue:
+		attr.Values = []*string{renderAttributeValue(value)}
+	case hasValues:
+		list, ok := values.([]interface{})
+		if !ok && values != nil {
+			return nil, fmt.Errorf("values must be a list")
+		}
+		attr.Values = make([]*string, 0, len(list))
+		for _, v := range list {
+			attr.Values = append(attr.Values, renderAttributeValue(v))
+		}
+	}
+
+	return attr, nil
+}
+
+// renderAttributeValue formats a YAML value as attribute text. Timestamps are
+// rendered in xs:dateTime form; nil is returned as a nil pointer.
+func renderAttributeValue(v interface{}) *string {
+	if v == nil {
+		return nil
+	}
+	var s string
+	if t, ok := v.(time.Time); ok {
+		s = t.UTC().Format(time.RFC3339)
+	} else {
+		s = cast.ToString(v)
+	}
+	return &s
+}
+
+// checkAttributes checks the map form of each of a user's attributes,
+// reporting problems at the attribute.
+func (c *Config) checkAttributes(userPath string, user *User) []Problem {
+	names := make([]string, 0, len(user.Attributes))
+	for name := range user.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []Problem
+	for _, name := range names {
+		decl, ok := user.Attributes[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, err := parseAttributeDeclaration(name, decl); err != nil {
+			problems = append(problems, c.Problem(userPath+".attributes."+name, "%v", err))
+		}
+	}
+	return problems
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseAttributeDeclaration(t *testing.T) {
+	var attrs map[string]interface{}
+	err := yaml.Unmarshal([]byte(`
+employeeNumber:
+  name_format: uri
+  friendly_name: employeeNumber
+  type: xs:integer
+  value: 42
+lastLogin:
+  type: xs:dateTime
+  value: 2024-01-02T03:04:05Z
+untyped:
+  type: none
+  values: ["a", "", null]
+empty:
+  value: null
+novalues: {}
+`), &attrs)
+	if err != nil {
+		t.Fatalf("Failed to parse YAML: %v", err)
+	}
+
+	parse := func(name string) *AttributeDeclaration {
+		t.Helper()
+		decl, err := ParseAttributeDeclaration(name, attrs[name].(map[string]interface{}))
+		if err != nil {
+			t.Fatalf("ParseAttributeDeclaration(%s) failed: %v", name, err)
+		}
+		return decl
+	}
+
+	decl := parse("employeeNumber")
+	if decl.NameFormat != AttrNameFormatURI {
+		t.Errorf("Expected uri NameFormat, got %q", decl.NameFormat)
+	}
+	if decl.FriendlyName != "employeeNumber" || decl.Type != "xs:integer" {
+		t.Errorf("Unexpected declaration: %+v", decl)
+	}
+	if len(decl.Values) != 1 || *decl.Values[0] != "42" {
+		t.Errorf("Expected value 42, got %v", decl.Values)
+	}
+
+	decl = parse("lastLogin")
+	if len(decl.Values) != 1 || *decl.Values[0] != "2024-01-02T03:04:05Z" {
+		t.Errorf("Expected dateTime value, got %v", decl.Values)
+	}
+
+	decl = parse("untyped")
+	if decl.Type != "" {
+		t.Errorf("Expected no type, got %q", decl.Type)
+	}
+	if decl.NameFormat != AttrNameFormatBasic || decl.FriendlyName != "" {
+		t.Errorf("Expected basic NameFormat and no FriendlyName, got %+v", decl)
+	}
+	if len(decl.Values) != 3 || *decl.Values[1] != "" || decl.Values[2] != nil {
+		t.Errorf("Expected values [a, \"\", nil], got %v", decl.Values)
+	}
+
+	decl = parse("empty")
+	if len(decl.Values) != 1 || decl.Values[0] != nil {
+		t.Errorf("Expected single nil value, got %v", decl.Values)
+	}
+
+	decl = parse("novalues")
+	if len(decl.Values) != 0 {
+		t.Errorf("Expected no values, got %v", decl.Values)
+	}
+}
+
+func TestParseAttributeDeclarationInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		decl map[string]interface{}
+	}{
+		{"unknown key", map[string]interface{}{"valeu": "x"}},
+		{"unknown name format", map[string]interface{}{"name_format": "fancy"}},
+		{"unknown type", map[string]interface{}{"type": "integer"}},
+		{"value and values", map[string]interface{}{"value": "x", "values": []interface{}{"y"}}},
+		{"values not a list", map[string]interface{}{"values": "x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseAttributeDeclaration("attr", tt.decl); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidAttributeDeclaration(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    users:
+      - name: "Test User"
+        name_id: "test@example.com"
+        attributes:
+          age:
+            type: "number"
+            value: 42
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected error for invalid attribute declaration")
+	}
+}
@@ -8,34 +8,122 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-
-	"gopkg.in/yaml.v3"
+	"time"
 )
 
 // Config represents the full application configuration.
 type Config struct {
 	Server           ServerConfig      `yaml:"server"`
 	IDP              IDPConfig         `yaml:"idp"`
+	Logging          LoggingConfig     `yaml:"logging"`
+	Metrics          MetricsConfig     `yaml:"metrics"`
+	Health           HealthConfig      `yaml:"health"`
+	History          HistoryConfig     `yaml:"history"`
+	PendingRequests  PendingConfig     `yaml:"pending_requests"`
+	Deterministic    Deterministic     `yaml:"deterministic"`
+	Webhooks         []Webhook         `yaml:"webhooks"`
 	ServiceProviders []ServiceProvider `yaml:"service_providers"`
+	Tenants          []Tenant          `yaml:"tenants"`
 
 	// baseDir is the directory containing the config file, used for resolving relative paths
 	baseDir string
+	// source is the parsed config file, and pathPrefix the location of a
+	// tenant's settings within it, for reporting problems
+	source     *source
+	pathPrefix string
 }
 
 // ServerConfig contains HTTP server settings.
 type ServerConfig struct {
-	Host    string `yaml:"host"`
-	Port    int    `yaml:"port"`
-	BaseURL string `yaml:"base_url"`
+	Host    string    `yaml:"host"`
+	Port    int       `yaml:"port"`
+	BaseURL string    `yaml:"base_url"`
+	Debug   bool      `yaml:"debug"`
+	TLS     TLSConfig `yaml:"tls"`
+	// TrustedProxies are the IP addresses or CIDR ranges of reverse
+	// proxies whose X-Forwarded-* and Forwarded headers are honoured.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 // IDPConfig contains the Identity Provider settings.
 type IDPConfig struct {
-	EntityID        string `yaml:"entity_id"`
-	Certificate     string `yaml:"certificate"`
-	CertificatePath string `yaml:"certificate_path"`
-	PrivateKey      string `yaml:"private_key"`
-	PrivateKeyPath  string `yaml:"private_key_path"`
+	EntityID         string `yaml:"entity_id"`
+	Certificate      string `yaml:"certificate"`
+	CertificatePath  string `yaml:"certificate_path"`
+	PrivateKey       string `yaml:"private_key"`
+	PrivateKeyPath   string `yaml:"private_key_path"`
+	PersistentIDSalt string `yaml:"persistent_id_salt"`
+
+	// baseDir is inherited from Config for resolving relative paths
+	baseDir string
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint.
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Address, if set, serves /metrics on a separate listener instead of
+	// the main server.
+	Address string `yaml:"address"`
+}
+
+// DefaultCertificateWarningDays is the certificate expiry warning period
+// when the configuration doesn't set one.
+const DefaultCertificateWarningDays = 30
+
+// HealthConfig controls the readiness checks.
+type HealthConfig struct {
+	// CertificateWarningDays is how long before the signing certificate
+	// expires /readyz starts warning about it.
+	CertificateWarningDays int `yaml:"certificate_warning_days"`
+}
+
+// CertificateWarningPeriod returns the certificate expiry warning period.
+func (h *HealthConfig) CertificateWarningPeriod() time.Duration {
+	days := h.CertificateWarningDays
+	if days <= 0 {
+		days = DefaultCertificateWarningDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// PendingConfig controls how long AuthnRequests wait for the user to log in.
+type PendingConfig struct {
+	// TTL is how long a pending request stays valid.
+	TTL time.Duration `yaml:"ttl"`
+	// SweepInterval is how often expired requests are removed.
+	SweepInterval time.Duration `yaml:"sweep_interval"`
+	// Max caps the number of pending requests; the oldest are evicted.
+	Max int `yaml:"max"`
+}
+
+// DefaultDeterministicTime is the fixed time of deterministic mode when the
+// configuration doesn't set one.
+var DefaultDeterministicTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Deterministic makes identical logins produce identical responses, for
+// snapshot tests.
+type Deterministic struct {
+	Enabled bool `yaml:"enabled"`
+	// Time is the fixed time used in issued messages.
+	Time time.Time `yaml:"time"`
+	// Seed seeds the generated IDs.
+	Seed uint64 `yaml:"seed"`
+}
+
+// StartTime returns the fixed time used in issued messages.
+func (d *Deterministic) StartTime() time.Time {
+	if d.Time.IsZero() {
+		return DefaultDeterministicTime
+	}
+	return d.Time
+}
+
+// HistoryConfig controls the history of requests and responses.
+type HistoryConfig struct {
+	// Size is the number of entries kept in memory.
+	Size int `yaml:"size"`
+	// File, if set, is a JSONL file every entry is appended to.
+	File string `yaml:"file"`
 
 	// baseDir is inherited from Config for resolving relative paths
 	baseDir string
@@ -43,11 +131,21 @@ type IDPConfig struct {
 
 // ServiceProvider represents a configured SP with its users.
 type ServiceProvider struct {
-	EntityID     string `yaml:"entity_id"`
-	ACSURL       string `yaml:"acs_url"`
-	MetadataFile string `yaml:"metadata_file"`
-	NameIDFormat string `yaml:"name_id_format"`
-	Users        []User `yaml:"users"`
+	EntityID            string         `yaml:"entity_id"`
+	ACSURL              string         `yaml:"acs_url"`
+	MetadataFile        string         `yaml:"metadata_file"`
+	NameIDFormat        string         `yaml:"name_id_format"`
+	MFA                 string         `yaml:"mfa"`
+	AllowCustomIdentity bool           `yaml:"allow_custom_identity"`
+	AttributeProfile    string         `yaml:"attribute_profile"`
+	SubjectIDReq        string         `yaml:"subject_id_req"`
+	SubjectIDScope      string         `yaml:"subject_id_scope"`
+	AttributeRelease    *ReleasePolicy `yaml:"attribute_release"`
+	Consent             bool           `yaml:"consent"`
+	ClockOffset         time.Duration  `yaml:"clock_offset"`
+	AssertionValidity   time.Duration  `yaml:"assertion_validity"`
+	SessionLifetime     time.Duration  `yaml:"session_lifetime"`
+	Users               []User         `yaml:"users"`
 
 	// baseDir is inherited from Config for resolving relative paths
 	baseDir string
@@ -55,54 +153,107 @@ type ServiceProvider struct {
 
 // User represents a test user with attributes.
 type User struct {
-	Name       string                 `yaml:"name"`
-	NameID     string                 `yaml:"name_id"`
-	Attributes map[string]interface{} `yaml:"attributes"`
+	Name         string                 `yaml:"name"`
+	NameID       string                 `yaml:"name_id"`
+	NameIDFormat string                 `yaml:"name_id_format"`
+	StaticNameID bool                   `yaml:"static_name_id"`
+	NameIDs      map[string]string      `yaml:"name_ids"`
+	SubjectID    string                 `yaml:"subject_id"`
+	MFA          string                 `yaml:"mfa"`
+	TOTPSecret   string                 `yaml:"totp_secret"`
+	Attributes   map[string]interface{} `yaml:"attributes"`
 }
 
-// LoadConfig loads configuration from a YAML file.
+// LoadConfig loads configuration from a YAML file, failing if Validate
+// finds any errors.
 func LoadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+	cfg, problems, err := Validate(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
-
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+		return nil, err
 	}
-
-	// Store the config file's directory for resolving relative paths
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute path of config file: %w", err)
+	if errs := Errors(problems); len(errs) > 0 {
+		return nil, &ValidationError{Problems: errs}
 	}
-	cfg.baseDir = filepath.Dir(absPath)
+	return cfg, nil
+}
 
+// setDefaults propagates the config file's directory and fills in default
+// settings.
+func (c *Config) setDefaults() {
 	// Propagate baseDir to IDP config
-	cfg.IDP.baseDir = cfg.baseDir
+	c.IDP.baseDir = c.baseDir
+	c.History.baseDir = c.baseDir
+	c.Server.TLS.baseDir = c.baseDir
 
 	// Propagate baseDir to service providers
-	for i := range cfg.ServiceProviders {
-		cfg.ServiceProviders[i].baseDir = cfg.baseDir
+	for i := range c.ServiceProviders {
+		c.ServiceProviders[i].baseDir = c.baseDir
+	}
+	for i := range c.Tenants {
+		tenant := &c.Tenants[i]
+		tenant.IDP.baseDir = c.baseDir
+		for j := range tenant.ServiceProviders {
+			tenant.ServiceProviders[j].baseDir = c.baseDir
+		}
 	}
 
 	// Set defaults
-	if cfg.Server.Host == "" {
-		cfg.Server.Host = "localhost"
+	if c.Server.Host == "" {
+		c.Server.Host = "localhost"
 	}
-	if cfg.Server.Port == 0 {
-		cfg.Server.Port = 8080
+	if c.Server.Port == 0 {
+		c.Server.Port = 8080
 	}
 
 	// Set default Name ID format for SPs
-	for i := range cfg.ServiceProviders {
-		if cfg.ServiceProviders[i].NameIDFormat == "" {
-			cfg.ServiceProviders[i].NameIDFormat = "email"
+	setDefaultNameIDFormats(c.ServiceProviders)
+	for i := range c.Tenants {
+		setDefaultNameIDFormats(c.Tenants[i].ServiceProviders)
+	}
+}
+
+// setDefaultNameIDFormats defaults SPs without a name_id_format to email.
+func setDefaultNameIDFormats(sps []ServiceProvider) {
+	for i := range sps {
+		if sps[i].NameIDFormat == "" {
+			sps[i].NameIDFormat = "email"
 		}
 	}
+}
+
+// MFA methods supported for the simulated second factor.
+const (
+	MFANone = "none"
+	MFATOTP = "totp"
+	MFAPush = "push"
+)
+
+// checkMFA checks that an SP's MFA setting names a known method.
+func (c *Config) checkMFA(path string, sp *ServiceProvider) []Problem {
+	if !isMFAMethod(sp.MFA) {
+		return []Problem{c.Problem(path+".mfa", "unknown method %q", sp.MFA)}
+	}
+	return nil
+}
+
+// checkUserMFA checks that a user's MFA setting names a known method and
+// that a user challenged with TOTP has a secret configured.
+func (c *Config) checkUserMFA(userPath string, sp *ServiceProvider, user *User) []Problem {
+	switch {
+	case !isMFAMethod(user.MFA):
+		return []Problem{c.Problem(userPath+".mfa", "unknown method %q", user.MFA)}
+	case sp.MFAMethod(user) == MFATOTP && user.TOTPSecret == "":
+		return []Problem{c.Problem(userPath+".totp_secret", "required for totp mfa")}
+	}
+	return nil
+}
 
-	return &cfg, nil
+func isMFAMethod(method string) bool {
+	switch method {
+	case "", MFANone, MFATOTP, MFAPush:
+		return true
+	}
+	return false
 }
 
 // resolvePath resolves a path relative to the config file's directory.
@@ -187,6 +338,11 @@ func (c *IDPConfig) LoadPrivateKey() (*rsa.PrivateKey, error) {
 	return key, nil
 }
 
+// GetFilePath returns the resolved history file path.
+func (h *HistoryConfig) GetFilePath() string {
+	return resolvePath(h.baseDir, h.File)
+}
+
 // GetMetadataFilePath returns the resolved metadata file path.
 func (sp *ServiceProvider) GetMetadataFilePath() string {
 	return resolvePath(sp.baseDir, sp.MetadataFile)
@@ -201,3 +357,25 @@ func (sp *ServiceProvider) GetUserByName(name string) *User {
 	}
 	return nil
 }
+
+// MFAMethod returns the MFA method to challenge a user with when signing in
+// to this SP. A method set on the user takes precedence over the SP setting.
+func (sp *ServiceProvider) MFAMethod(user *User) string {
+	method := sp.MFA
+	if user != nil && user.MFA != "" {
+		method = user.MFA
+	}
+	if method == "" {
+		return MFANone
+	}
+	return method
+}
+
+// NameIDFormatFor returns the NameID format to use for a user signing in to
+// this SP. A format set on the user takes precedence over the SP setting.
+func (sp *ServiceProvider) NameIDFormatFor(user *User) string {
+	if user != nil && user.NameIDFormat != "" {
+		return user.NameIDFormat
+	}
+	return sp.NameIDFormat
+}
@@ -247,3 +247,97 @@ func TestLoadPrivateKey(t *testing.T) {
 		t.Error("Expected error for missing private key")
 	}
 }
+
+func TestServiceProviderMFAMethod(t *testing.T) {
+	sp := &ServiceProvider{MFA: MFAPush}
+
+	if got := sp.MFAMethod(&User{}); got != MFAPush {
+		t.Errorf("Expected SP method %q, got %q", MFAPush, got)
+	}
+	if got := sp.MFAMethod(&User{MFA: MFATOTP}); got != MFATOTP {
+		t.Errorf("Expected user override %q, got %q", MFATOTP, got)
+	}
+	if got := sp.MFAMethod(&User{MFA: MFANone}); got != MFANone {
+		t.Errorf("Expected user opt-out %q, got %q", MFANone, got)
+	}
+	if got := (&ServiceProvider{}).MFAMethod(&User{}); got != MFANone {
+		t.Errorf("Expected default %q, got %q", MFANone, got)
+	}
+}
+
+func TestServiceProviderNameIDFormatFor(t *testing.T) {
+	sp := &ServiceProvider{NameIDFormat: "email"}
+
+	if got := sp.NameIDFormatFor(&User{}); got != "email" {
+		t.Errorf("Expected SP format %q, got %q", "email", got)
+	}
+	if got := sp.NameIDFormatFor(&User{NameIDFormat: "persistent"}); got != "persistent" {
+		t.Errorf("Expected user override %q, got %q", "persistent", got)
+	}
+	if got := sp.NameIDFormatFor(nil); got != "email" {
+		t.Errorf("Expected SP format for nil user, got %q", got)
+	}
+}
+
+func TestLoadConfigUserNameIDFormat(t *testing.T) {
+	content := `
+service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    name_id_format: "email"
+    users:
+      - name: "Test User"
+        name_id: "test@example.com"
+        name_id_format: "unspecified"
+`
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	sp := &cfg.ServiceProviders[0]
+	if got := sp.NameIDFormatFor(&sp.Users[0]); got != "unspecified" {
+		t.Errorf("Expected user NameID format 'unspecified', got %q", got)
+	}
+}
+
+func TestLoadConfigInvalidMFA(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unknown method", `
+service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    mfa: "sms"
+`},
+		{"totp without secret", `
+service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    mfa: "totp"
+    users:
+      - name: "Test User"
+        name_id: "test@example.com"
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+
+			if _, err := LoadConfig(configPath); err == nil {
+				t.Error("Expected error for invalid MFA config")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the names of environment variables that override
+// server and idp settings, e.g. SAML_IDP_SERVER_BASE_URL.
+const EnvPrefix = "SAML_IDP_"
+
+// envRefRe matches ${VAR}, ${VAR:-default} and the escape $${.
+var envRefRe = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:-)([^}]*))?\}`)
+
+// interpolate substitutes environment variables into the config file's
+// values. ${VAR} must be set; ${VAR:-default} uses the default if VAR is
+// unset or empty. $${ is a literal ${.
+func (c *Config) interpolate(node *yaml.Node) []Problem {
+	var problems []Problem
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			problems = append(problems, c.interpolate(child)...)
+		}
+	case yaml.MappingNode:
+		// Only values; setting names are never substituted
+		for i := 1; i < len(node.Content); i += 2 {
+			problems = append(problems, c.interpolate(node.Content[i])...)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			break
+		}
+		node.Value = envRefRe.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			if ref == "$${" {
+				return "${"
+			}
+			m := envRefRe.FindStringSubmatch(ref)
+			value, ok := os.LookupEnv(m[1])
+			switch {
+			case m[2] != "" && value == "":
+				return m[3]
+			case !ok:
+				p := Problem{Line: node.Line, Column: node.Column, Message: fmt.Sprintf("environment variable %s is not set", m[1])}
+				if c.source != nil {
+					p.File = c.source.file
+				}
+				problems = append(problems, p)
+			}
+			return value
+		})
+		// Let plain values be resolved again, so ${PORT} can be an int
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	}
+	return problems
+}
+
+// applyEnvOverrides sets server and idp settings from SAML_IDP_*
+// environment variables, named after the section and setting, e.g.
+// SAML_IDP_SERVER_PORT or SAML_IDP_IDP_PRIVATE_KEY. With tenants, which
+// set their own base_url and idp, those variables are reported as ignored.
+func (c *Config) applyEnvOverrides(lookup func(string) (string, bool)) []Problem {
+	sections := []struct {
+		name     string
+		settings interface{}
+	}{
+		{"server", &c.Server},
+		{"idp", &c.IDP},
+	}
+
+	var problems []Problem
+	for _, section := range sections {
+		v := reflect.ValueOf(section.settings).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" || !field.IsExported() {
+				continue
+			}
+			name := EnvPrefix + strings.ToUpper(section.name+"_"+tag)
+			value, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if len(c.Tenants) > 0 && (section.name == "idp" || tag == "base_url") {
+				problems = append(problems, c.Warning("tenants", "%s is ignored, as each tenant sets its own base_url and idp", name))
+				continue
+			}
+			if err := setField(v.Field(i), value); err != nil {
+				problems = append(problems, c.Problem(section.name+"."+tag, "%s: %v", name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// setField parses an environment variable into a string, int or bool
+// setting. Other kinds of setting can't be overridden.
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("can't be set from the environment")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LoggingConfig controls the server's log output.
+type LoggingConfig struct {
+	// Level is debug, info, warn or error. Defaults to info.
+	Level string `yaml:"level"`
+	// Format is text or json. Defaults to text.
+	Format string `yaml:"format"`
+}
+
+// checkLogging checks the level and format are known.
+func (c *Config) checkLogging() []Problem {
+	var problems []Problem
+	if _, err := c.Logging.level(); err != nil {
+		problems = append(problems, c.Problem("logging.level", "%v", err))
+	}
+	switch c.Logging.Format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		problems = append(problems, c.Problem("logging.format", "unknown format %q", c.Logging.Format))
+	}
+	return problems
+}
+
+func (l *LoggingConfig) level() (slog.Level, error) {
+	var level slog.Level
+	if l.Level == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(strings.ToLower(l.Level))); err != nil {
+		return 0, fmt.Errorf("unknown level %q", l.Level)
+	}
+	return level, nil
+}
+
+// NewLogger creates a logger writing to w with the configured level and
+// format.
+func (l *LoggingConfig) NewLogger(w io.Writer) (*slog.Logger, error) {
+	level, err := l.level()
+	if err != nil {
+		return nil, fmt.Errorf("logging: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch l.Format {
+	case "", LogFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("logging: unknown format %q", l.Format)
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoggingNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := LoggingConfig{Level: "WARN", Format: LogFormatJSON}
+	logger, err := cfg.NewLogger(&buf)
+	if err != nil {
+		t.Fatalf("NewLogger failed: %v", err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("shown", "sp", "https://sp.example.com")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 line at warn level, got %d: %s", len(lines), buf.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Expected JSON output: %v", err)
+	}
+	if record["msg"] != "shown" || record["sp"] != "https://sp.example.com" {
+		t.Errorf("Unexpected record: %v", record)
+	}
+}
+
+func TestCheckLogging(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   LoggingConfig
+		paths []string
+	}{
+		{"defaults", LoggingConfig{}, nil},
+		{"debug text", LoggingConfig{Level: "debug", Format: LogFormatText}, nil},
+		{"unknown level", LoggingConfig{Level: "verbose"}, []string{"logging.level"}},
+		{"unknown format", LoggingConfig{Format: "xml"}, []string{"logging.format"}},
+		{"both", LoggingConfig{Level: "verbose", Format: "xml"}, []string{"logging.level", "logging.format"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := (&Config{Logging: tt.cfg}).checkLogging()
+			if got := problemPaths(problems); !reflect.DeepEqual(got, tt.paths) {
+				t.Errorf("checkLogging() = %v, want problems at %v", problems, tt.paths)
+			}
+		})
+	}
+}
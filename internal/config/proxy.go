@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// checkTrustedProxies checks every trusted proxy is an IP address or CIDR
+// range.
+func (c *Config) checkTrustedProxies() []Problem {
+	var problems []Problem
+	for i, proxy := range c.Server.TrustedProxies {
+		if _, err := parsePrefix(proxy); err != nil {
+			problems = append(problems, c.Problem(fmt.Sprintf("server.trusted_proxies[%d]", i), "%q is not an IP address or CIDR range", proxy))
+		}
+	}
+	return problems
+}
+
+// TrustsProxy reports whether a request from remoteAddr (an IP address,
+// optionally with a port) came from a trusted proxy.
+func (s *ServerConfig) TrustsProxy(remoteAddr string) bool {
+	if len(s.TrustedProxies) == 0 {
+		return false
+	}
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, proxy := range s.TrustedProxies {
+		if prefix, err := parsePrefix(proxy); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix parses a CIDR range, or a single IP address as a range
+// containing only itself.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckTrustedProxies(t *testing.T) {
+	valid := &Config{Server: ServerConfig{TrustedProxies: []string{"10.0.0.0/8", "127.0.0.1", "::1"}}}
+	if problems := valid.checkTrustedProxies(); len(problems) != 0 {
+		t.Errorf("Expected valid proxies, got %v", problems)
+	}
+
+	invalid := &Config{Server: ServerConfig{TrustedProxies: []string{"proxy.internal", "10.0.0.1", "10.0.0.0/33"}}}
+	want := []string{"server.trusted_proxies[0]", "server.trusted_proxies[2]"}
+	if got := problemPaths(invalid.checkTrustedProxies()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected problems at %v, got %v", want, got)
+	}
+}
+
+func TestTrustsProxy(t *testing.T) {
+	cfg := ServerConfig{TrustedProxies: []string{"10.0.0.0/8", "127.0.0.1", "::1"}}
+
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"10.1.2.3:41000", true},
+		{"127.0.0.1:41000", true},
+		{"[::1]:41000", true},
+		{"[::ffff:10.1.2.3]:41000", true},
+		{"192.168.1.1:41000", false},
+		{"127.0.0.2", false},
+		{"not-an-address", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.TrustsProxy(tt.remoteAddr); got != tt.want {
+			t.Errorf("TrustsProxy(%q) = %v, want %v", tt.remoteAddr, got, tt.want)
+		}
+	}
+
+	if (&ServerConfig{}).TrustsProxy("127.0.0.1:41000") {
+		t.Error("Expected no proxies to be trusted by default")
+	}
+}
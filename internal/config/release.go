@@ -0,0 +1,49 @@
+package config
+
+import (
+	"regexp"
+	"sort"
+)
+
+// ReleasePolicy restricts the attributes released to a service provider.
+// Names match either an attribute's Name or its FriendlyName, after any
+// attribute profile has been applied.
+//
+//	attribute_release:
+//	  allow: [email, firstName, groups]
+//	  deny: [employeeNumber]
+//	  values:
+//	    groups: "^app-"
+//	  requested_only: true
+type ReleasePolicy struct {
+	// Allow lists the attributes that may be released; empty allows all.
+	Allow []string `yaml:"allow"`
+	// Deny lists attributes that are never released.
+	Deny []string `yaml:"deny"`
+	// Values maps attribute names to a regular expression; only matching
+	// values are released, and attributes left without values are dropped.
+	Values map[string]string `yaml:"values"`
+	// RequestedOnly releases only the RequestedAttributes of the
+	// AttributeConsumingService in the SP's metadata.
+	RequestedOnly bool `yaml:"requested_only"`
+}
+
+// checkAttributeRelease checks the value patterns of an SP's release policy.
+func (c *Config) checkAttributeRelease(path string, sp *ServiceProvider) []Problem {
+	if sp.AttributeRelease == nil {
+		return nil
+	}
+	names := make([]string, 0, len(sp.AttributeRelease.Values))
+	for name := range sp.AttributeRelease.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []Problem
+	for _, name := range names {
+		if _, err := regexp.Compile(sp.AttributeRelease.Values[name]); err != nil {
+			problems = append(problems, c.Problem(path+".attribute_release.values."+name, "%v", err))
+		}
+	}
+	return problems
+}
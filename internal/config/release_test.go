@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigAttributeRelease(t *testing.T) {
+	content := `
+service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    attribute_release:
+      allow: [email, groups]
+      deny: [ssn]
+      values:
+        groups: "^app-"
+      requested_only: true
+`
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	policy := cfg.ServiceProviders[0].AttributeRelease
+	if policy == nil {
+		t.Fatal("Expected attribute_release to be parsed")
+	}
+	if len(policy.Allow) != 2 || len(policy.Deny) != 1 || policy.Values["groups"] != "^app-" || !policy.RequestedOnly {
+		t.Errorf("Unexpected release policy: %+v", policy)
+	}
+}
+
+func TestLoadConfigInvalidAttributeRelease(t *testing.T) {
+	content := `
+service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    attribute_release:
+      values:
+        groups: "app-("
+`
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected error for invalid value pattern")
+	}
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Tenant is an independent IdP served by the same process, with its own
+// entity ID, keys, service providers and users.
+type Tenant struct {
+	// Name identifies the tenant in logs and on the landing page.
+	Name string `yaml:"name"`
+	// BaseURL is where the tenant is served. Its path prefixes the
+	// tenant's endpoints.
+	BaseURL          string            `yaml:"base_url"`
+	IDP              IDPConfig         `yaml:"idp"`
+	ServiceProviders []ServiceProvider `yaml:"service_providers"`
+}
+
+var tenantNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// TenantConfigs returns the configuration of each IdP to serve: one per
+// tenant, sharing the other settings, or just c without tenants.
+func (c *Config) TenantConfigs() []*Config {
+	if len(c.Tenants) == 0 {
+		return []*Config{c}
+	}
+
+	configs := make([]*Config, len(c.Tenants))
+	for i := range c.Tenants {
+		tenant := &c.Tenants[i]
+		cfg := *c
+		cfg.Tenants = nil
+		cfg.pathPrefix = fmt.Sprintf("tenants[%d].", i)
+		cfg.Server.BaseURL = tenant.BaseURL
+		cfg.IDP = tenant.IDP
+		cfg.ServiceProviders = tenant.ServiceProviders
+		// Each tenant keeps its own history file
+		if cfg.History.File != "" {
+			ext := filepath.Ext(cfg.History.File)
+			cfg.History.File = strings.TrimSuffix(cfg.History.File, ext) + "-" + tenant.Name + ext
+		}
+		configs[i] = &cfg
+	}
+	return configs
+}
+
+// checkTenants checks every tenant has a unique name and base URL, and
+// that service providers aren't also configured outside the tenants.
+func (c *Config) checkTenants() []Problem {
+	if len(c.Tenants) == 0 {
+		return nil
+	}
+
+	var problems []Problem
+	if len(c.ServiceProviders) > 0 {
+		problems = append(problems, c.Problem("service_providers", "must be configured per tenant when tenants are used"))
+	}
+
+	names := make(map[string]int)
+	baseURLs := make(map[string]int)
+	for i := range c.Tenants {
+		tenant := &c.Tenants[i]
+		path := fmt.Sprintf("tenants[%d]", i)
+
+		if first, seen := names[tenant.Name]; seen {
+			problems = append(problems, c.Problem(path+".name", "duplicate name %q (also tenants[%d])", tenant.Name, first))
+		} else if !tenantNameRe.MatchString(tenant.Name) {
+			problems = append(problems, c.Problem(path+".name", "%q must be letters, digits, '.', '-' or '_'", tenant.Name))
+		} else {
+			names[tenant.Name] = i
+		}
+
+		if err := checkURL(tenant.BaseURL); err != nil {
+			problems = append(problems, c.Problem(path+".base_url", "%v", err))
+			continue
+		}
+		u, _ := url.Parse(tenant.BaseURL)
+		key := u.Hostname() + strings.TrimSuffix(u.Path, "/")
+		if first, seen := baseURLs[key]; seen {
+			problems = append(problems, c.Problem(path+".base_url", "also used by tenants[%d]", first))
+		} else {
+			baseURLs[key] = i
+		}
+	}
+	return problems
+}
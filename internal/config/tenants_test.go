@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigTenants(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	configContent := `
+history:
+  file: "history.jsonl"
+
+tenants:
+  - name: acme
+    base_url: "http://localhost:8080/acme"
+    idp:
+      certificate_path: "acme.crt"
+      private_key_path: "acme.key"
+    service_providers:
+      - entity_id: "https://sp.example.com"
+        acs_url: "https://sp.example.com/acs"
+        users:
+          - name: "Acme User"
+            name_id: "user@acme.example"
+  - name: globex
+    base_url: "http://localhost:8080/globex"
+    idp:
+      entity_id: "urn:globex:idp"
+      certificate_path: "globex.crt"
+      private_key_path: "globex.key"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	configs := cfg.TenantConfigs()
+	if len(configs) != 2 {
+		t.Fatalf("Expected 2 tenant configs, got %d", len(configs))
+	}
+
+	acme := configs[0]
+	if acme.Server.BaseURL != "http://localhost:8080/acme" {
+		t.Errorf("Unexpected acme base URL %s", acme.Server.BaseURL)
+	}
+	if acme.IDP.baseDir != tmpDir || acme.ServiceProviders[0].baseDir != tmpDir {
+		t.Errorf("Expected tenant paths relative to the config, got %s", acme.IDP.baseDir)
+	}
+	if acme.ServiceProviders[0].NameIDFormat != "email" {
+		t.Errorf("Expected default name_id_format, got %q", acme.ServiceProviders[0].NameIDFormat)
+	}
+	if acme.History.GetFilePath() != filepath.Join(tmpDir, "history-acme.jsonl") {
+		t.Errorf("Expected per-tenant history file, got %s", acme.History.GetFilePath())
+	}
+	if configs[1].IDP.EntityID != "urn:globex:idp" || len(configs[1].ServiceProviders) != 0 {
+		t.Errorf("Unexpected globex config: %+v", configs[1].IDP)
+	}
+	if cfg.Server.Port != 8080 || acme.Server.Port != 8080 {
+		t.Error("Expected tenants to share the server settings")
+	}
+}
+
+func TestTenantConfigsWithoutTenants(t *testing.T) {
+	cfg := &Config{}
+	if configs := cfg.TenantConfigs(); len(configs) != 1 || configs[0] != cfg {
+		t.Error("Expected the config itself without tenants")
+	}
+}
+
+func TestCheckTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"valid", Config{Tenants: []Tenant{
+			{Name: "a", BaseURL: "http://localhost:8080/a"},
+			{Name: "b", BaseURL: "http://localhost:8080/b"},
+			{Name: "c", BaseURL: "http://c.localhost:8080"},
+		}}, ""},
+		{"top-level SPs", Config{
+			ServiceProviders: []ServiceProvider{{EntityID: "https://sp.example.com"}},
+			Tenants:          []Tenant{{Name: "a", BaseURL: "http://localhost:8080/a"}},
+		}, "per tenant"},
+		{"bad name", Config{Tenants: []Tenant{{Name: "a/b", BaseURL: "http://localhost:8080/a"}}}, "name"},
+		{"duplicate name", Config{Tenants: []Tenant{
+			{Name: "a", BaseURL: "http://localhost:8080/a"},
+			{Name: "a", BaseURL: "http://localhost:8080/b"},
+		}}, "duplicate"},
+		{"relative base URL", Config{Tenants: []Tenant{{Name: "a", BaseURL: "/a"}}}, "invalid URL"},
+		{"duplicate base URL", Config{Tenants: []Tenant{
+			{Name: "a", BaseURL: "http://localhost:8080/a"},
+			{Name: "b", BaseURL: "https://localhost:8443/a/"},
+		}}, "also used by tenants[0]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := tt.cfg.checkTenants()
+			if tt.wantErr == "" {
+				if len(problems) != 0 {
+					t.Errorf("Expected no problems, got %v", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0].String(), tt.wantErr) {
+				t.Errorf("Expected a problem containing %q, got %v", tt.wantErr, problems)
+			}
+		})
+	}
+
+	// Every tenant's problems are reported
+	cfg := Config{Tenants: []Tenant{
+		{Name: "a/b", BaseURL: "/a"},
+		{Name: "c", BaseURL: "http://localhost:8080/c"},
+		{Name: "c", BaseURL: "http://localhost:8080/c"},
+	}}
+	want := []string{"tenants[0].name", "tenants[0].base_url", "tenants[2].name", "tenants[2].base_url"}
+	if got := problemPaths(cfg.checkTenants()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected problems at %v, got %v", want, got)
+	}
+}
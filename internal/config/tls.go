@@ -0,0 +1,265 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultTLSCertDir is where auto-generated certificates are kept when the
+// configuration doesn't set cert_dir.
+const DefaultTLSCertDir = "tls"
+
+// Auto-generated certificate files, within cert_dir.
+const (
+	TLSCACertFile = "ca.pem"
+	TLSCAKeyFile  = "ca-key.pem"
+)
+
+// TLSConfig controls HTTPS. TLS is enabled by setting cert_file and key_file
+// or auto_generate.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// MinVersion is "1.2" or "1.3". Defaults to 1.2.
+	MinVersion string `yaml:"min_version"`
+	// ClientCAFile, if set, requires clients to present a certificate
+	// signed by one of its CAs.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// AutoGenerate creates a local CA and a serving certificate for Hosts.
+	AutoGenerate bool `yaml:"auto_generate"`
+	// Hosts are the names and IP addresses in the generated certificate.
+	Hosts []string `yaml:"hosts"`
+	// CertDir holds the generated CA, which is reused across restarts.
+	CertDir string `yaml:"cert_dir"`
+
+	// baseDir is inherited from Config for resolving relative paths
+	baseDir string
+}
+
+// Enabled reports whether the server should listen with TLS.
+func (t *TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.AutoGenerate
+}
+
+// checkTLS checks the TLS settings are consistent.
+func (c *Config) checkTLS() []Problem {
+	t := &c.Server.TLS
+	var problems []Problem
+	switch {
+	case t.CertFile != "" && t.KeyFile == "":
+		problems = append(problems, c.Problem("server.tls.key_file", "required when cert_file is set"))
+	case t.CertFile == "" && t.KeyFile != "":
+		problems = append(problems, c.Problem("server.tls.cert_file", "required when key_file is set"))
+	}
+	if t.CertFile != "" && t.AutoGenerate {
+		problems = append(problems, c.Problem("server.tls.auto_generate", "use either cert_file or auto_generate"))
+	}
+	if _, err := t.minVersion(); err != nil {
+		problems = append(problems, c.Problem("server.tls.min_version", "%v", err))
+	}
+	return problems
+}
+
+// checkBaseURLSchemes warns about http base URLs when the listener only
+// serves HTTPS, as the IdP would advertise endpoints it doesn't serve.
+func (c *Config) checkBaseURLSchemes() []Problem {
+	if !c.Server.TLS.Enabled() {
+		return nil
+	}
+
+	var problems []Problem
+	warn := func(path, baseURL string) {
+		if u, err := url.Parse(baseURL); err == nil && u.Scheme == "http" {
+			problems = append(problems, c.Warning(path, "%s uses http but server.tls is enabled; use https", baseURL))
+		}
+	}
+	warn("server.base_url", c.Server.BaseURL)
+	for i := range c.Tenants {
+		warn(fmt.Sprintf("tenants[%d].base_url", i), c.Tenants[i].BaseURL)
+	}
+	return problems
+}
+
+func (t *TLSConfig) minVersion() (uint16, error) {
+	switch t.MinVersion {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown version %q (use 1.2 or 1.3)", t.MinVersion)
+}
+
+// GetCertDir returns the resolved directory for generated certificates.
+func (t *TLSConfig) GetCertDir() string {
+	dir := t.CertDir
+	if dir == "" {
+		dir = DefaultTLSCertDir
+	}
+	return resolvePath(t.baseDir, dir)
+}
+
+// GetCACertPath returns the path of the generated CA certificate, which
+// clients must trust.
+func (t *TLSConfig) GetCACertPath() string {
+	return filepath.Join(t.GetCertDir(), TLSCACertFile)
+}
+
+// ServerTLSConfig loads (or generates) the serving certificate. Generated
+// certificates cover Hosts, or defaultHosts if it's empty.
+func (t *TLSConfig) ServerTLSConfig(defaultHosts []string) (*tls.Config, error) {
+	minVersion, err := t.minVersion()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{MinVersion: minVersion}
+
+	var cert tls.Certificate
+	if t.AutoGenerate {
+		hosts := t.Hosts
+		if len(hosts) == 0 {
+			hosts = defaultHosts
+		}
+		cert, err = t.generateCertificate(hosts)
+	} else {
+		cert, err = tls.LoadX509KeyPair(resolvePath(t.baseDir, t.CertFile), resolvePath(t.baseDir, t.KeyFile))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	if t.ClientCAFile != "" {
+		pemData, err := os.ReadFile(resolvePath(t.baseDir, t.ClientCAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, errors.New("no certificates found in client CA file")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// generateCertificate issues a serving certificate for hosts from the local
+// CA in cert_dir, creating the CA first if needed.
+func (t *TLSConfig) generateCertificate(hosts []string) (tls.Certificate, error) {
+	caCert, caKey, err := t.loadOrCreateCA()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template, err := certificateTemplate("saml-test-idp")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.NotAfter = template.NotBefore.Add(90 * 24 * time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, caCert.Raw}, PrivateKey: key}, nil
+}
+
+// loadOrCreateCA reads the local CA from cert_dir, or creates and saves a
+// new one.
+func (t *TLSConfig) loadOrCreateCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPath := t.GetCACertPath()
+	keyPath := filepath.Join(t.GetCertDir(), TLSCAKeyFile)
+
+	if pair, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not an ECDSA key", keyPath)
+		}
+		return cert, key, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := certificateTemplate("saml-test-idp local CA")
+	if err != nil {
+		return nil, nil, err
+	}
+	template.NotAfter = template.NotBefore.Add(10 * 365 * 24 * time.Hour)
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(t.GetCertDir(), 0755); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// certificateTemplate returns a template with a random serial number,
+// valid from an hour ago to allow for clock differences.
+func certificateTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"saml-test-idp"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+	}, nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckTLS(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   TLSConfig
+		paths []string
+	}{
+		{"disabled", TLSConfig{}, nil},
+		{"cert and key", TLSConfig{CertFile: "server.crt", KeyFile: "server.key"}, nil},
+		{"auto generate", TLSConfig{AutoGenerate: true, MinVersion: "1.3"}, nil},
+		{"cert without key", TLSConfig{CertFile: "server.crt"}, []string{"server.tls.key_file"}},
+		{"key without cert", TLSConfig{KeyFile: "server.key"}, []string{"server.tls.cert_file"}},
+		{"cert and auto generate", TLSConfig{CertFile: "server.crt", KeyFile: "server.key", AutoGenerate: true}, []string{"server.tls.auto_generate"}},
+		{"unknown min version", TLSConfig{AutoGenerate: true, MinVersion: "1.1"}, []string{"server.tls.min_version"}},
+		{"several", TLSConfig{CertFile: "server.crt", AutoGenerate: true, MinVersion: "1.1"},
+			[]string{"server.tls.key_file", "server.tls.auto_generate", "server.tls.min_version"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := (&Config{Server: ServerConfig{TLS: tt.cfg}}).checkTLS()
+			if got := problemPaths(problems); !reflect.DeepEqual(got, tt.paths) {
+				t.Errorf("checkTLS() = %v, want problems at %v", problems, tt.paths)
+			}
+		})
+	}
+}
+
+func TestValidateHTTPBaseURLWithTLS(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `server:
+  base_url: "http://localhost:8443"
+  tls:
+    auto_generate: true
+tenants:
+  - name: acme
+    base_url: "https://localhost:8443/acme"
+  - name: globex
+    base_url: "http://localhost:8443/globex"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	_, problems, err := Validate(configPath)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", problems)
+	}
+	if p := problems[0]; !p.Warning || p.Line != 2 || p.Path != "server.base_url" {
+		t.Errorf("Expected a warning for server.base_url, got %s", p)
+	}
+	if p := problems[1]; !p.Warning || p.Line != 9 || p.Path != "tenants[1].base_url" {
+		t.Errorf("Expected a warning for the globex base_url, got %s", p)
+	}
+}
+
+func TestTLSAutoGenerate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := TLSConfig{AutoGenerate: true, Hosts: []string{"idp.test", "127.0.0.1"}, baseDir: dir}
+
+	tlsConfig, err := cfg.ServerTLSConfig(nil)
+	if err != nil {
+		t.Fatalf("ServerTLSConfig failed: %v", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Expected TLS 1.2 minimum, got %x", tlsConfig.MinVersion)
+	}
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, DefaultTLSCertDir, TLSCACertFile))
+	if err != nil {
+		t.Fatalf("Expected CA to be exported: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatal("Exported CA is not a PEM certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse serving certificate: %v", err)
+	}
+	for _, host := range []string{"idp.test", "127.0.0.1"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("Certificate not valid for %s: %v", host, err)
+		}
+	}
+
+	// A restart reuses the CA, so it only needs trusting once
+	if _, err := cfg.ServerTLSConfig(nil); err != nil {
+		t.Fatalf("ServerTLSConfig failed on reuse: %v", err)
+	}
+	reused, _ := os.ReadFile(cfg.GetCACertPath())
+	if string(reused) != string(caPEM) {
+		t.Error("Expected the CA to be reused")
+	}
+}
+
+func TestTLSClientCA(t *testing.T) {
+	dir := t.TempDir()
+	caCfg := TLSConfig{AutoGenerate: true, Hosts: []string{"localhost"}, baseDir: dir}
+	if _, err := caCfg.ServerTLSConfig(nil); err != nil {
+		t.Fatalf("ServerTLSConfig failed: %v", err)
+	}
+
+	cfg := TLSConfig{
+		CertFile:     "../../testdata/test.crt",
+		KeyFile:      "../../testdata/test.key",
+		ClientCAFile: caCfg.GetCACertPath(),
+		MinVersion:   "1.3",
+	}
+	tlsConfig, err := cfg.ServerTLSConfig(nil)
+	if err != nil {
+		t.Fatalf("ServerTLSConfig failed: %v", err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.ClientCAs == nil {
+		t.Error("Expected client certificates to be required")
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3 minimum, got %x", tlsConfig.MinVersion)
+	}
+}
@@ -0,0 +1,407 @@
+package config
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is an error or warning in the configuration, with its location
+// in the config file where known.
+type Problem struct {
+	File   string
+	Line   int
+	Column int
+	// Path is the setting at fault, e.g. service_providers[0].acs_url.
+	Path    string
+	Message string
+	// Warning marks problems that don't stop the server starting.
+	Warning bool
+}
+
+// String formats the problem as file:line:column: path: message.
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.File != "" {
+		b.WriteString(p.File)
+		if p.Line > 0 {
+			fmt.Fprintf(&b, ":%d", p.Line)
+		}
+		if p.Column > 0 {
+			fmt.Fprintf(&b, ":%d", p.Column)
+		}
+		b.WriteString(": ")
+	}
+	if p.Warning {
+		b.WriteString("warning: ")
+	}
+	if p.Path != "" {
+		b.WriteString(p.Path + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// ValidationError lists every error found in the configuration.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("invalid configuration (%d error(s)):\n  %s", len(lines), strings.Join(lines, "\n  "))
+}
+
+// Errors returns the problems that aren't warnings.
+func Errors(problems []Problem) []Problem {
+	var errs []Problem
+	for _, p := range problems {
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+	return errs
+}
+
+// source is the parsed config file, for locating problems.
+type source struct {
+	file string
+	root *yaml.Node
+}
+
+// Problem returns an error at the setting with the given path, relative to
+// this configuration (so within a tenant, relative to the tenant).
+func (c *Config) Problem(path, format string, args ...interface{}) Problem {
+	p := Problem{Path: c.pathPrefix + path, Message: fmt.Sprintf(format, args...)}
+	if c.source != nil {
+		p.File = c.source.file
+		if node := locate(c.source.root, p.Path); node != nil {
+			p.Line, p.Column = node.Line, node.Column
+		}
+	}
+	return p
+}
+
+// Warning is Problem for problems that don't stop the server starting.
+func (c *Config) Warning(path, format string, args ...interface{}) Problem {
+	p := c.Problem(path, format, args...)
+	p.Warning = true
+	return p
+}
+
+var pathSegmentRe = regexp.MustCompile(`^([^\[]*)((?:\[\d+\])*)$`)
+
+// locate finds the node for a path such as service_providers[0].users[1],
+// or its nearest ancestor in the file if the setting is omitted.
+func locate(root *yaml.Node, path string) *yaml.Node {
+	node := root
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node == nil || path == "" {
+		return node
+	}
+
+	segments := strings.Split(path, ".")
+	for n, segment := range segments {
+		m := pathSegmentRe.FindStringSubmatch(segment)
+		if m == nil {
+			return node
+		}
+		if m[1] != "" {
+			key, value := mappingEntry(node, m[1])
+			if key == nil {
+				return node
+			}
+			// Settings are reported at their name
+			if n == len(segments)-1 && m[2] == "" {
+				return key
+			}
+			node = value
+		}
+		for _, index := range strings.Split(strings.Trim(m[2], "[]"), "][") {
+			if index == "" {
+				continue
+			}
+			i, _ := strconv.Atoi(index)
+			if node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+				return node
+			}
+			node = node.Content[i]
+		}
+	}
+	return node
+}
+
+// mappingEntry returns the key and value nodes of a key in a mapping node.
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+var (
+	typeErrorLineRe = regexp.MustCompile(`^line (\d+): (.*)$`)
+	unknownFieldRe  = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// Validate loads a config file and checks it, returning every problem
+// found. Unlike LoadConfig it returns the configuration even if it has
+// problems; it's nil only if the file can't be read or parsed.
+func Validate(path string) (*Config, []Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	// Decode strictly, so misspelt settings aren't silently ignored. Values
+	// are only checked once environment variables are substituted.
+	var problems []Problem
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&Config{}); err != nil && !errors.Is(err, io.EOF) {
+		decoded, err := decodeProblems(path, &root, err)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+		for _, p := range decoded {
+			if strings.HasPrefix(p.Message, "unknown setting") {
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	cfg := Config{source: &source{file: path, root: &root}}
+	problems = append(problems, cfg.interpolate(&root)...)
+	if root.Kind != 0 {
+		if err := root.Decode(&cfg); err != nil {
+			decoded, err := decodeProblems(path, &root, err)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+			}
+			problems = append(problems, decoded...)
+		}
+	}
+	problems = append(problems, cfg.applyEnvOverrides(os.LookupEnv)...)
+
+	// Store the config file's directory for resolving relative paths
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get absolute path of config file: %w", err)
+	}
+	cfg.baseDir = filepath.Dir(absPath)
+	cfg.setDefaults()
+
+	problems = append(problems, cfg.check()...)
+	SortProblems(problems)
+	return &cfg, problems, nil
+}
+
+// decodeProblems converts the errors from decoding the config file into
+// problems. Errors other than a yaml.TypeError are returned as they are.
+func decodeProblems(file string, root *yaml.Node, err error) ([]Problem, error) {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return nil, err
+	}
+
+	problems := make([]Problem, 0, len(typeErr.Errors))
+	for _, msg := range typeErr.Errors {
+		p := Problem{File: file, Message: msg}
+		if m := typeErrorLineRe.FindStringSubmatch(msg); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Message = m[2]
+		}
+		if m := unknownFieldRe.FindStringSubmatch(p.Message); m != nil {
+			p.Message = fmt.Sprintf("unknown setting %q", m[1])
+			p.Path, p.Column = findKey(root, "", p.Line, m[1])
+		}
+		problems = append(problems, p)
+	}
+	return problems, nil
+}
+
+// SortProblems orders problems by their line in the config file.
+func SortProblems(problems []Problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+}
+
+// findKey returns the path and column of the mapping key on a line, for
+// locating settings the decoder reports only by line.
+func findKey(node *yaml.Node, path string, line int, key string) (string, int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if p, col := findKey(child, path, line, key); col > 0 {
+				return p, col
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			if p, col := findKey(child, fmt.Sprintf("%s[%d]", path, i), line, key); col > 0 {
+				return p, col
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i]
+			childPath := k.Value
+			if path != "" {
+				childPath = path + "." + k.Value
+			}
+			if k.Line == line && k.Value == key {
+				return childPath, k.Column
+			}
+			if p, col := findKey(node.Content[i+1], childPath, line, key); col > 0 {
+				return p, col
+			}
+		}
+	}
+	return "", 0
+}
+
+// check validates the settings, returning every problem found.
+func (c *Config) check() []Problem {
+	var problems []Problem
+	problems = append(problems, c.checkLogging()...)
+	problems = append(problems, c.checkTLS()...)
+	problems = append(problems, c.checkTrustedProxies()...)
+	if c.Server.BaseURL != "" {
+		if err := checkURL(c.Server.BaseURL); err != nil {
+			problems = append(problems, c.Problem("server.base_url", "%v", err))
+		}
+	}
+	problems = append(problems, c.checkBaseURLSchemes()...)
+	problems = append(problems, c.checkTenants()...)
+	problems = append(problems, c.checkWebhooks()...)
+
+	for _, tenantCfg := range c.TenantConfigs() {
+		problems = append(problems, tenantCfg.checkServiceProviders()...)
+	}
+	return problems
+}
+
+// checkServiceProviders validates each service provider and its users.
+func (c *Config) checkServiceProviders() []Problem {
+	var problems []Problem
+	entityIDs := make(map[string]int)
+
+	for i := range c.ServiceProviders {
+		sp := &c.ServiceProviders[i]
+		path := fmt.Sprintf("service_providers[%d]", i)
+
+		switch first, seen := entityIDs[sp.EntityID]; {
+		case sp.EntityID == "":
+			problems = append(problems, c.Problem(path, "entity_id is required"))
+		case seen:
+			problems = append(problems, c.Problem(path+".entity_id", "duplicate entity_id %q (also service_providers[%d])", sp.EntityID, first))
+		default:
+			entityIDs[sp.EntityID] = i
+		}
+
+		switch {
+		case sp.ACSURL != "":
+			if err := checkURL(sp.ACSURL); err != nil {
+				problems = append(problems, c.Problem(path+".acs_url", "%v", err))
+			}
+		case sp.MetadataFile == "":
+			problems = append(problems, c.Problem(path, "SP must have either acs_url or metadata_file"))
+		}
+
+		problems = append(problems, c.checkMFA(path, sp)...)
+		problems = append(problems, c.checkAttributeRelease(path, sp)...)
+
+		names := make(map[string]int)
+		for j := range sp.Users {
+			user := &sp.Users[j]
+			userPath := fmt.Sprintf("%s.users[%d]", path, j)
+			if first, seen := names[user.Name]; seen {
+				problems = append(problems, c.Problem(userPath+".name", "duplicate user name %q (also users[%d])", user.Name, first))
+			} else {
+				names[user.Name] = j
+			}
+			problems = append(problems, c.checkUserMFA(userPath, sp, user)...)
+			problems = append(problems, c.checkAttributes(userPath, user)...)
+		}
+	}
+	return problems
+}
+
+// checkURL checks a URL is absolute, with an http or https scheme.
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q (must be an absolute http or https URL)", raw)
+	}
+	return nil
+}
+
+// CheckKeys loads the signing certificate and key of each IdP, checking
+// they match. Certificates that are expired, not yet valid or expire within
+// the health warning period are reported as warnings, since testing with
+// them can be deliberate.
+func (c *Config) CheckKeys(now time.Time) []Problem {
+	var problems []Problem
+	for _, tenantCfg := range c.TenantConfigs() {
+		problems = append(problems, tenantCfg.checkKeys(now)...)
+	}
+	return problems
+}
+
+func (c *Config) checkKeys(now time.Time) []Problem {
+	cert, err := c.IDP.LoadCertificate()
+	if err != nil {
+		return []Problem{c.Problem("idp", "%v", err)}
+	}
+	key, err := c.IDP.LoadPrivateKey()
+	if err != nil {
+		return []Problem{c.Problem("idp", "%v", err)}
+	}
+
+	var problems []Problem
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		problems = append(problems, c.Problem("idp", "private key does not match the certificate"))
+	}
+	problems = append(problems, c.checkCertificateValidity(cert, now)...)
+	return problems
+}
+
+func (c *Config) checkCertificateValidity(cert *x509.Certificate, now time.Time) []Problem {
+	switch {
+	case now.Before(cert.NotBefore):
+		return []Problem{c.Warning("idp", "certificate is not valid until %s", cert.NotBefore.UTC().Format(time.RFC3339))}
+	case now.After(cert.NotAfter):
+		return []Problem{c.Warning("idp", "certificate expired %s", cert.NotAfter.UTC().Format(time.RFC3339))}
+	case cert.NotAfter.Sub(now) < c.Health.CertificateWarningPeriod():
+		return []Problem{c.Warning("idp", "certificate expires %s", cert.NotAfter.UTC().Format(time.RFC3339))}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// problemPaths returns the path of each problem.
+func problemPaths(problems []Problem) []string {
+	var paths []string
+	for _, p := range problems {
+		paths = append(paths, p.Path)
+	}
+	return paths
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `server:
+  base_url: "localhost:8080"
+  prot: 9000
+service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    users:
+      - name: "Alice"
+        name_id: "alice@example.com"
+        mfa: "sms"
+      - name: "Alice"
+        name_id: "alice2@example.com"
+  - entity_id: "https://sp.example.com"
+    acs_url: "/acs"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, problems, err := Validate(configPath)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if cfg == nil {
+		t.Fatal("Expected the config despite its problems")
+	}
+
+	want := []struct {
+		line int
+		path string
+		msg  string
+	}{
+		{2, "server.base_url", "invalid URL"},
+		{3, "server.prot", `unknown setting "prot"`},
+		{10, "service_providers[0].users[0].mfa", `unknown method "sms"`},
+		{11, "service_providers[0].users[1].name", `duplicate user name "Alice"`},
+		{13, "service_providers[1].entity_id", "duplicate entity_id"},
+		{14, "service_providers[1].acs_url", "invalid URL"},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %d: %v", len(want), len(problems), problems)
+	}
+	for i, w := range want {
+		p := problems[i]
+		if p.Line != w.line || p.Path != w.path || !strings.Contains(p.Message, w.msg) || p.File != configPath {
+			t.Errorf("Problem %d: expected line %d %s: %s, got %s", i, w.line, w.path, w.msg, p)
+		}
+	}
+
+	_, err = LoadConfig(configPath)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != len(want) {
+		t.Errorf("Expected LoadConfig to fail with every problem, got %v", err)
+	}
+}
+
+func TestValidateServiceProviderProblems(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    mfa: "sms"
+    attribute_release:
+      values:
+        groups: "app-("
+    users:
+      - name: "a"
+        name_id: "a@example.com"
+        mfa: "foo"
+        attributes:
+          y:
+            type: "number"
+          x:
+            valeu: "1"
+      - name: "a"
+        name_id: "a2@example.com"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	_, problems, err := Validate(configPath)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	want := []struct {
+		line int
+		path string
+	}{
+		{4, "service_providers[0].mfa"},
+		{7, "service_providers[0].attribute_release.values.groups"},
+		{11, "service_providers[0].users[0].mfa"},
+		{13, "service_providers[0].users[0].attributes.y"},
+		{15, "service_providers[0].users[0].attributes.x"},
+		{17, "service_providers[0].users[1].name"},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %d: %v", len(want), len(problems), problems)
+	}
+	for i, w := range want {
+		if p := problems[i]; p.Line != w.line || p.Path != w.path {
+			t.Errorf("Problem %d: expected line %d %s, got %s", i, w.line, w.path, p)
+		}
+	}
+}
+
+func TestValidateTenantLocations(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `tenants:
+  - name: acme
+    base_url: "http://localhost:8080/acme"
+    idp:
+      certificat_path: "acme.crt"
+    service_providers:
+      - entity_id: "https://sp.example.com"
+        acs_url: "/acs"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	_, problems, err := Validate(configPath)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", problems)
+	}
+	if p := problems[0]; p.Line != 5 || p.Column != 7 || p.Path != "tenants[0].idp.certificat_path" {
+		t.Errorf("Unexpected unknown setting location: %s", p)
+	}
+	if p := problems[1]; p.Line != 8 || p.Path != "tenants[0].service_providers[0].acs_url" {
+		t.Errorf("Unexpected tenant SP location: %s", p)
+	}
+}
+
+func TestProblemString(t *testing.T) {
+	p := Problem{File: "config.yaml", Line: 3, Column: 5, Path: "server.port", Message: "bad", Warning: true}
+	if got := p.String(); got != "config.yaml:3:5: warning: server.port: bad" {
+		t.Errorf("Unexpected format: %s", got)
+	}
+	if got := (Problem{Message: "bad"}).String(); got != "bad" {
+		t.Errorf("Unexpected format without a location: %s", got)
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	cfg := &Config{IDP: IDPConfig{
+		CertificatePath: "../../testdata/test.crt",
+		PrivateKeyPath:  "../../testdata/test.key",
+	}}
+	cert, err := cfg.IDP.LoadCertificate()
+	if err != nil {
+		t.Fatalf("LoadCertificate failed: %v", err)
+	}
+
+	if problems := cfg.CheckKeys(cert.NotBefore.Add(time.Hour)); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+	if problems := cfg.CheckKeys(cert.NotAfter.Add(-24 * time.Hour)); len(problems) != 1 || !problems[0].Warning {
+		t.Errorf("Expected an expiry warning, got %v", problems)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.IDP.PrivateKeyPath = ""
+	cfg.IDP.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	problems := cfg.CheckKeys(cert.NotBefore.Add(time.Hour))
+	if len(problems) != 1 || problems[0].Warning || !strings.Contains(problems[0].Message, "does not match") {
+		t.Errorf("Expected a key mismatch error, got %v", problems)
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import "fmt"
+
+// Webhook events.
+const (
+	WebhookEventLoginSuccess = "login.success"
+	WebhookEventLoginFailure = "login.failure"
+	WebhookEventSSOError     = "sso.error"
+)
+
+// WebhookEvents lists every webhook event.
+var WebhookEvents = []string{WebhookEventLoginSuccess, WebhookEventLoginFailure, WebhookEventSSOError}
+
+// DefaultWebhookMaxRetries is the number of retries when max_retries is unset.
+const DefaultWebhookMaxRetries = 3
+
+// Webhook is an outbound notification of authentication events.
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Secret, if set, signs each delivery with an HMAC-SHA256 of its body.
+	Secret string `yaml:"secret"`
+	// Events limits the events sent; empty sends all of them.
+	Events []string `yaml:"events"`
+	// MaxRetries is the number of retries after a failed delivery.
+	MaxRetries *int `yaml:"max_retries"`
+}
+
+// Wants reports whether the webhook should receive an event.
+func (h *Webhook) Wants(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Retries returns the number of retries after a failed delivery.
+func (h *Webhook) Retries() int {
+	if h.MaxRetries == nil {
+		return DefaultWebhookMaxRetries
+	}
+	return *h.MaxRetries
+}
+
+// checkWebhooks checks every webhook's URL, events and retries.
+func (c *Config) checkWebhooks() []Problem {
+	var problems []Problem
+	for i := range c.Webhooks {
+		hook := &c.Webhooks[i]
+		path := fmt.Sprintf("webhooks[%d]", i)
+		if checkURL(hook.URL) != nil {
+			problems = append(problems, c.Problem(path+".url", "invalid url %q", hook.URL))
+		}
+		for j, event := range hook.Events {
+			if !isWebhookEvent(event) {
+				problems = append(problems, c.Problem(fmt.Sprintf("%s.events[%d]", path, j), "unknown event %q", event))
+			}
+		}
+		if hook.MaxRetries != nil && *hook.MaxRetries < 0 {
+			problems = append(problems, c.Problem(path+".max_retries", "must not be negative"))
+		}
+	}
+	return problems
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
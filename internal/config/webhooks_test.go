@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigWebhooks(t *testing.T) {
+	content := `
+webhooks:
+  - url: "http://localhost:9000/hook"
+    secret: "s3cret"
+    events: [login.success, sso.error]
+  - url: "https://hooks.example.com/saml"
+    max_retries: 0
+`
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if len(cfg.Webhooks) != 2 {
+		t.Fatalf("Expected 2 webhooks, got %d", len(cfg.Webhooks))
+	}
+
+	hook := cfg.Webhooks[0]
+	if !hook.Wants(WebhookEventLoginSuccess) || hook.Wants(WebhookEventLoginFailure) {
+		t.Errorf("Unexpected event filter: %v", hook.Events)
+	}
+	if hook.Retries() != DefaultWebhookMaxRetries {
+		t.Errorf("Expected default retries, got %d", hook.Retries())
+	}
+	if !cfg.Webhooks[1].Wants(WebhookEventLoginFailure) || cfg.Webhooks[1].Retries() != 0 {
+		t.Errorf("Expected all events and no retries, got %+v", cfg.Webhooks[1])
+	}
+}
+
+func TestLoadConfigInvalidWebhooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook string
+		wantErr string
+	}{
+		{"missing url", `secret: "x"`, "invalid url"},
+		{"relative url", `url: "/hook"`, "invalid url"},
+		{"unknown event", `{url: "http://localhost/hook", events: [logout]}`, `unknown event "logout"`},
+		{"negative retries", `{url: "http://localhost/hook", max_retries: -1}`, "max_retries"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "webhooks:\n  - " + tt.webhook + "\n"
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+
+			_, err := LoadConfig(configPath)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckWebhooks(t *testing.T) {
+	retries := -1
+	cfg := &Config{Webhooks: []Webhook{
+		{URL: "http://localhost/hook"},
+		{URL: "/hook", Events: []string{WebhookEventSSOError, "logout"}, MaxRetries: &retries},
+	}}
+
+	want := []string{"webhooks[1].url", "webhooks[1].events[1]", "webhooks[1].max_retries"}
+	if got := problemPaths(cfg.checkWebhooks()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected problems at %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,57 @@
+package idp
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Clock supplies the time used in issued SAML messages and for validating
+// AuthnRequests. Pending requests, history and webhooks use the wall clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock reads the wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock returns the wall clock. It's the server's default.
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+// fixedClock always returns the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+// FixedClock returns a clock stopped at t.
+func FixedClock(t time.Time) Clock {
+	return fixedClock(t)
+}
+
+// SeededIDSource returns an IDSource producing the same sequence of IDs for
+// the same seed.
+func SeededIDSource(seed uint64) IDSource {
+	var key [32]byte
+	for i := 0; i < 8; i++ {
+		key[i] = byte(seed >> (8 * i))
+	}
+	return NewIDSource(rand.NewChaCha8(key))
+}
+
+// SetDeterministic makes identical logins produce identical responses: the
+// clock is stopped at t, IDs come from a source seeded with seed and the
+// client address is left out of assertions. Attributes are always sent in
+// name order.
+func (s *Server) SetDeterministic(t time.Time, seed uint64) {
+	s.SetClock(FixedClock(t))
+	s.SetIDSource(SeededIDSource(seed))
+	s.deterministic = true
+}
+
+// SetClock replaces the clock used for issued SAML messages.
+func (s *Server) SetClock(clock Clock) {
+	s.clock = clock
+}
@@ -0,0 +1,120 @@
+package idp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+)
+
+var deterministicTime = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// deterministicLogin logs in on a deterministic server with an AuthnRequest
+// issued at deterministicTime from remoteAddr, after showing the login page
+// previews times, and returns the response XML.
+func deterministicLogin(t *testing.T, seed uint64, remoteAddr string, previews int) string {
+	t.Helper()
+
+	server := testServer(t)
+	server.SetDeterministic(deterministicTime, seed)
+	server.config.ServiceProviders[0].Users[0].Attributes = map[string]interface{}{
+		"email":       "test@example.com",
+		"displayName": "Test User",
+		"groups":      []interface{}{"admins", "users"},
+		"sessionId":   "{{ uuid }}",
+		"requestId":   "{{ uuid }}",
+	}
+
+	authnRequest := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" `+
+		`xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-test-request" Version="2.0" `+
+		`IssueInstant="%s" AssertionConsumerServiceURL="https://sp.example.com/acs">`+
+		`<saml:Issuer>https://sp.example.com</saml:Issuer></samlp:AuthnRequest>`,
+		deterministicTime.Format(time.RFC3339))
+	form := url.Values{}
+	form.Set("SAMLRequest", base64.StdEncoding.EncodeToString([]byte(authnRequest)))
+	req := httptest.NewRequest("POST", "/sso", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	server.handleSSO(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status 302 from /sso, got %d: %s", w.Code, w.Body.String())
+	}
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+	loginURL := "/login?request_id=" + location.Query().Get("request_id")
+	for i := 0; i < previews; i++ {
+		w = httptest.NewRecorder()
+		server.handleLogin(w, httptest.NewRequest("GET", loginURL, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 from GET /login, got %d", w.Code)
+		}
+	}
+
+	req = httptest.NewRequest("POST", loginURL, strings.NewReader(url.Values{"user": {"Test User"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = remoteAddr
+	w = httptest.NewRecorder()
+	server.handleLogin(w, req)
+	return decodeSAMLResponse(t, w.Body.String())
+}
+
+func TestDeterministicResponses(t *testing.T) {
+	// Neither the client address nor showing the login page changes it
+	first := deterministicLogin(t, 1, "192.0.2.1:1234", 0)
+	for i := 1; i <= 5; i++ {
+		if second := deterministicLogin(t, 1, "198.51.100.7:5678", i); first != second {
+			t.Fatalf("Expected identical responses in deterministic mode:\n%s\n%s", first, second)
+		}
+	}
+	if other := deterministicLogin(t, 2, "192.0.2.1:1234", 0); first == other {
+		t.Error("Expected a different seed to produce different IDs")
+	}
+
+	if strings.Contains(first, "192.0.2.1") {
+		t.Errorf("Expected no client address in the response, got %s", first)
+	}
+	if !strings.Contains(first, `IssueInstant="2025-01-01T12:00:00Z"`) {
+		t.Errorf("Expected the fixed time in the response, got %s", first)
+	}
+	display, email, groups := strings.Index(first, `Name="displayName"`), strings.Index(first, `Name="email"`), strings.Index(first, `Name="groups"`)
+	if display < 0 || !(display < email && email < groups) {
+		t.Error("Expected attributes in name order")
+	}
+}
+
+func TestFixedClockValidatesAuthnRequests(t *testing.T) {
+	server := testServer(t)
+	server.SetClock(FixedClock(time.Now().Add(time.Hour)))
+
+	// postAuthnRequest issues the request at the wall clock time, which is
+	// too old for the server's clock
+	if w := postAuthnRequest(server, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected the AuthnRequest to be rejected against the fixed clock, got %d", w.Code)
+	}
+}
+
+func TestNewDeterministicFromConfig(t *testing.T) {
+	server := testServer(t)
+	cfg := server.config
+	cfg.Deterministic = config.Deterministic{Enabled: true, Seed: 7}
+
+	deterministic, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer deterministic.Close()
+
+	if !deterministic.clock.Now().Equal(config.DefaultDeterministicTime) {
+		t.Errorf("Expected the default deterministic time, got %v", deterministic.clock.Now())
+	}
+	if deterministic.ids.HexID(16) != SeededIDSource(7).HexID(16) {
+		t.Error("Expected IDs seeded from the config")
+	}
+}
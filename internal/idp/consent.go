@@ -0,0 +1,164 @@
+package idp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// ConsentPageData holds data for the consent template.
+type ConsentPageData struct {
+	RequestID    string
+	SPName       string
+	User         *config.User
+	NameID       string
+	NameIDFormat string
+	AuthnContext string
+	Attributes   []ConsentAttribute
+}
+
+// ConsentAttribute is an attribute listed on the consent page. Attributes
+// without values are released or withheld as a whole using their ID.
+type ConsentAttribute struct {
+	ID           string
+	Name         string
+	FriendlyName string
+	Values       []ConsentValue
+}
+
+// ConsentValue is a single attribute value listed on the consent page.
+type ConsentValue struct {
+	ID    string
+	Value string
+	Nil   bool
+}
+
+// consentAttributeID identifies an attribute within an assertion's
+// attribute statements; consentValueID identifies one of its values.
+func consentAttributeID(stmt, attr int) string {
+	return fmt.Sprintf("%d.%d", stmt, attr)
+}
+
+func consentValueID(stmt, attr, value int) string {
+	return fmt.Sprintf("%d.%d.%d", stmt, attr, value)
+}
+
+// showConsentPage renders the assertion about to be sent for review.
+func (s *Server) showConsentPage(w http.ResponseWriter, requestID string, pendingSession *SessionData, user *config.User) {
+	assertion := pendingSession.SAMLRequest.Assertion
+	data := ConsentPageData{
+		RequestID: requestID,
+		SPName:    pendingSession.SP.EntityID,
+		User:      user,
+	}
+
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		data.NameID = assertion.Subject.NameID.Value
+		data.NameIDFormat = assertion.Subject.NameID.Format
+	}
+	for _, stmt := range assertion.AuthnStatements {
+		if ref := stmt.AuthnContext.AuthnContextClassRef; ref != nil {
+			data.AuthnContext = ref.Value
+		}
+	}
+
+	for i, stmt := range assertion.AttributeStatements {
+		for j, attr := range stmt.Attributes {
+			item := ConsentAttribute{
+				ID:           consentAttributeID(i, j),
+				Name:         attr.Name,
+				FriendlyName: attr.FriendlyName,
+			}
+			for k, v := range attr.Values {
+				item.Values = append(item.Values, ConsentValue{
+					ID:    consentValueID(i, j, k),
+					Value: v.Value,
+					Nil:   v.Type == xsiNilType,
+				})
+			}
+			data.Attributes = append(data.Attributes, item)
+		}
+	}
+
+	renderTemplate(w, "consent.html", data)
+}
+
+// handleConsent sends the reviewed assertion, without any attributes or
+// values the user unchecked, or a RequestDenied response if they decline.
+func (s *Server) handleConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID := r.URL.Query().Get("request_id")
+	if requestID == "" {
+		http.Error(w, "Missing request_id", http.StatusBadRequest)
+		return
+	}
+
+	pendingSession, ok := s.sessionProvider.GetPendingRequest(requestID)
+	if !ok {
+		http.Error(w, "Invalid or expired request", http.StatusBadRequest)
+		return
+	}
+	if !pendingSession.AwaitingConsent {
+		http.Error(w, "No assertion awaiting consent", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "decline":
+		s.failLogin(w, requestID, pendingSession, saml.StatusResponder, saml.StatusRequestDenied, "User declined to release the requested information")
+	case "accept":
+		withholdAttributes(pendingSession.SAMLRequest.Assertion, r.Form)
+		s.sendAssertion(w, pendingSession)
+		s.sessionProvider.DeletePendingRequest(requestID)
+	default:
+		http.Error(w, "Invalid action", http.StatusBadRequest)
+	}
+}
+
+// withholdAttributes removes the attributes and values not listed in the
+// form's release field. Attributes left without values are removed, as are
+// attribute statements left without attributes.
+func withholdAttributes(assertion *saml.Assertion, form url.Values) {
+	released := stringSet(form["release"])
+
+	statements := assertion.AttributeStatements[:0]
+	for i, stmt := range assertion.AttributeStatements {
+		attrs := stmt.Attributes[:0]
+		for j, attr := range stmt.Attributes {
+			if len(attr.Values) == 0 {
+				if released[consentAttributeID(i, j)] {
+					attrs = append(attrs, attr)
+				}
+				continue
+			}
+
+			values := attr.Values[:0]
+			for k, v := range attr.Values {
+				if released[consentValueID(i, j, k)] {
+					values = append(values, v)
+				}
+			}
+			if len(values) > 0 {
+				attr.Values = values
+				attrs = append(attrs, attr)
+			}
+		}
+		if len(attrs) > 0 {
+			stmt.Attributes = attrs
+			statements = append(statements, stmt)
+		}
+	}
+	assertion.AttributeStatements = statements
+}
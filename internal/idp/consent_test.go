@@ -0,0 +1,137 @@
+package idp
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/crewjam/saml"
+)
+
+func TestWithholdAttributes(t *testing.T) {
+	assertion := &saml.Assertion{
+		AttributeStatements: []saml.AttributeStatement{{
+			Attributes: []saml.Attribute{
+				{Name: "email", Values: attributeValues("alice@example.com")},
+				{Name: "groups", Values: attributeValues([]interface{}{"admins", "users", "staff"})},
+				{Name: "empty"},
+				{Name: "role", Values: attributeValues("admin")},
+			},
+		}},
+	}
+
+	withholdAttributes(assertion, url.Values{"release": {"0.0.0", "0.1.0", "0.1.2", "0.2"}})
+
+	attrs := assertion.AttributeStatements[0].Attributes
+	if len(attrs) != 3 {
+		t.Fatalf("Expected 3 attributes, got %d: %v", len(attrs), attrs)
+	}
+	if attrs[0].Name != "email" || attrs[1].Name != "groups" || attrs[2].Name != "empty" {
+		t.Errorf("Unexpected attributes released: %v", attrs)
+	}
+	if values := attrs[1].Values; len(values) != 2 || values[0].Value != "admins" || values[1].Value != "staff" {
+		t.Errorf("Expected unchecked group value to be withheld, got %v", values)
+	}
+
+	// Statements without attributes are removed
+	withholdAttributes(assertion, url.Values{})
+	if len(assertion.AttributeStatements) != 0 {
+		t.Errorf("Expected empty attribute statements to be removed, got %v", assertion.AttributeStatements)
+	}
+}
+
+func TestLoginFlowConsent(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.Consent = true
+	sp.Users[0].Attributes["groups"] = []interface{}{"admins", "users"}
+
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+
+	page := w.Body.String()
+	if strings.Contains(page, "SAMLResponse") {
+		t.Fatal("Expected consent page before the response is sent")
+	}
+	for _, want := range []string{"test@example.com", "groups", "<code>admins</code>", PasswordProtectedTransportAuthnContext} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected consent page to contain %q", want)
+		}
+	}
+
+	// Release everything except the "users" group
+	var release []string
+	for _, m := range consentCheckboxRe.FindAllStringSubmatch(page, -1) {
+		release = append(release, m[1])
+	}
+	pending, _ := server.sessionProvider.GetPendingRequest(requestID)
+	for i, stmt := range pending.SAMLRequest.Assertion.AttributeStatements {
+		for j, attr := range stmt.Attributes {
+			for k, v := range attr.Values {
+				if v.Value == "users" {
+					release = removeString(release, consentValueID(i, j, k))
+				}
+			}
+		}
+	}
+	w = postForm(server, server.handleConsent, "/consent?request_id="+requestID, url.Values{"action": {"accept"}, "release": release})
+	assertion := verifyResponse(t, server, decodeSAMLResponse(t, w.Body.String()))
+
+	var groups []string
+	for _, stmt := range assertion.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			if attr.Name == "groups" {
+				for _, v := range attr.Values {
+					groups = append(groups, v.Value)
+				}
+			}
+		}
+	}
+	if len(groups) != 1 || groups[0] != "admins" {
+		t.Errorf("Expected only the admins group to be released, got %v", groups)
+	}
+	if _, ok := server.sessionProvider.GetPendingRequest(requestID); ok {
+		t.Error("Expected pending request to be deleted after consent")
+	}
+}
+
+func TestLoginFlowConsentDeclined(t *testing.T) {
+	server := testServer(t)
+	server.spProvider.GetServiceProviderConfig("https://sp.example.com").Consent = true
+
+	requestID := startSSO(t, server)
+
+	// Consent can't be given before the user has authenticated
+	w := postForm(server, server.handleConsent, "/consent?request_id="+requestID, url.Values{"action": {"accept"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 before login, got %d", w.Code)
+	}
+
+	postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	w = postForm(server, server.handleConsent, "/consent?request_id="+requestID, url.Values{"action": {"decline"}})
+
+	response := decodeSAMLResponse(t, w.Body.String())
+	if !strings.Contains(response, saml.StatusRequestDenied) {
+		t.Error("Expected RequestDenied status")
+	}
+	if strings.Contains(response, "Assertion") {
+		t.Error("Expected no assertion in failure response")
+	}
+	if _, ok := server.sessionProvider.GetPendingRequest(requestID); ok {
+		t.Error("Expected pending request to be deleted after declining")
+	}
+}
+
+var consentCheckboxRe = regexp.MustCompile(`name="release" value="([0-9.]+)"`)
+
+func removeString(items []string, s string) []string {
+	out := items[:0]
+	for _, item := range items {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package idp
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+)
+
+// CustomIdentityName is the display name given to ad-hoc identities entered
+// on the login page.
+const CustomIdentityName = "Custom identity"
+
+// customIdentityFromForm builds a one-off user from the custom identity form.
+// Attribute rows are submitted as parallel attr_name/attr_values fields, with
+// one value per line; rows sharing a name are merged into a multi-valued
+// attribute and rows without a name are ignored.
+func customIdentityFromForm(form url.Values) (*config.User, error) {
+	nameID := strings.TrimSpace(form.Get("name_id"))
+	if nameID == "" {
+		return nil, fmt.Errorf("name_id is required")
+	}
+
+	format := form.Get("name_id_format")
+	if _, ok := NameIDFormats[format]; format != "" && !ok {
+		return nil, fmt.Errorf("unknown name_id_format %q", format)
+	}
+
+	names := form["attr_name"]
+	values := form["attr_values"]
+
+	collected := make(map[string][]string)
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var raw string
+		if i < len(values) {
+			raw = values[i]
+		}
+		collected[name] = append(collected[name], splitLines(raw)...)
+	}
+
+	attributes := make(map[string]interface{}, len(collected))
+	for name, vals := range collected {
+		if len(vals) == 1 {
+			attributes[name] = vals[0]
+		} else {
+			attributes[name] = vals
+		}
+	}
+
+	return &config.User{
+		Name:         CustomIdentityName,
+		NameID:       nameID,
+		NameIDFormat: format,
+		StaticNameID: true,
+		Attributes:   attributes,
+	}, nil
+}
+
+// splitLines splits a textarea value into lines, dropping the empty line
+// left by a trailing newline. An empty input yields a single empty value.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	lines := strings.Split(s, "\n")
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// nameIDFormatNames returns the config names of all supported NameID formats.
+func nameIDFormatNames() []string {
+	names := make([]string, 0, len(NameIDFormats))
+	for name := range NameIDFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,130 @@
+package idp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCustomIdentityFromForm(t *testing.T) {
+	form := url.Values{
+		"name_id":        {"jörg@example.com"},
+		"name_id_format": {"unspecified"},
+		"attr_name":      {"displayName", "groups", "", "groups"},
+		"attr_values":    {"Jörg Müller", "a\r\nb\r\n", "ignored", "c"},
+	}
+
+	user, err := customIdentityFromForm(form)
+	if err != nil {
+		t.Fatalf("customIdentityFromForm failed: %v", err)
+	}
+
+	if user.NameID != "jörg@example.com" {
+		t.Errorf("Expected NameID 'jörg@example.com', got '%s'", user.NameID)
+	}
+	if user.NameIDFormat != "unspecified" {
+		t.Errorf("Expected NameIDFormat 'unspecified', got '%s'", user.NameIDFormat)
+	}
+	if user.Attributes["displayName"] != "Jörg Müller" {
+		t.Errorf("Expected single-valued displayName, got %v", user.Attributes["displayName"])
+	}
+	groups, ok := user.Attributes["groups"].([]string)
+	if !ok || strings.Join(groups, ",") != "a,b,c" {
+		t.Errorf("Expected merged groups [a b c], got %v", user.Attributes["groups"])
+	}
+	if len(user.Attributes) != 2 {
+		t.Errorf("Expected 2 attributes, got %d", len(user.Attributes))
+	}
+}
+
+func TestCustomIdentityFromFormInvalid(t *testing.T) {
+	if _, err := customIdentityFromForm(url.Values{}); err == nil {
+		t.Error("Expected error for missing name_id")
+	}
+
+	form := url.Values{"name_id": {"x"}, "name_id_format": {"bogus"}}
+	if _, err := customIdentityFromForm(form); err == nil {
+		t.Error("Expected error for unknown name_id_format")
+	}
+}
+
+func TestCustomIdentityLogin(t *testing.T) {
+	server := testServer(t)
+	requestID := startSSO(t, server)
+
+	form := url.Values{
+		"identity":       {"custom"},
+		"name_id":        {"adhoc-123"},
+		"name_id_format": {"persistent"},
+		"attr_name":      {"displayName"},
+		"attr_values":    {"Ad Hoc"},
+	}
+
+	// Disabled by default
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, form)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d", w.Code)
+	}
+
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.AllowCustomIdentity = true
+
+	w = postForm(server, server.handleLogin, "/login?request_id="+requestID, form)
+	response := decodeSAMLResponse(t, w.Body.String())
+	if !strings.Contains(response, ">adhoc-123</saml:NameID>") {
+		t.Error("Expected custom NameID in response")
+	}
+	if !strings.Contains(response, "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent") {
+		t.Error("Expected persistent NameID format in response")
+	}
+	if !strings.Contains(response, ">Ad Hoc<") {
+		t.Error("Expected custom attribute value in response")
+	}
+}
+
+func TestCustomIdentityAttributesAreLiteral(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.AllowCustomIdentity = true
+	sp.Users[0].TOTPSecret = "JBSWY3DPEHPK3PXP"
+	requestID := startSSO(t, server)
+
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{
+		"identity":    {"custom"},
+		"name_id":     {"adhoc-123"},
+		"attr_name":   {"secret"},
+		"attr_values": {"{{(index .SP.Users 0).TOTPSecret}}"},
+	})
+	response := decodeSAMLResponse(t, w.Body.String())
+	if strings.Contains(response, "JBSWY3DPEHPK3PXP") {
+		t.Fatal("Expected custom attribute values not to be evaluated as templates")
+	}
+	if !strings.Contains(response, ">{{(index .SP.Users 0).TOTPSecret}}<") {
+		t.Errorf("Expected the custom attribute value as entered, got %s", response)
+	}
+}
+
+func TestLoginPageCustomIdentityPanel(t *testing.T) {
+	server := testServer(t)
+	requestID := startSSO(t, server)
+
+	render := func() string {
+		w := httptest.NewRecorder()
+		server.handleLogin(w, httptest.NewRequest("GET", "/login?request_id="+requestID, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	if strings.Contains(render(), `name="identity" value="custom"`) {
+		t.Error("Expected no custom identity panel by default")
+	}
+
+	server.spProvider.GetServiceProviderConfig("https://sp.example.com").AllowCustomIdentity = true
+	if !strings.Contains(render(), `name="identity" value="custom"`) {
+		t.Error("Expected custom identity panel when enabled")
+	}
+}
@@ -0,0 +1,135 @@
+package idp
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+)
+
+// DebugPageData holds data for the response preview template.
+type DebugPageData struct {
+	Form         *saml.IdpAuthnRequestForm
+	Binding      string
+	SPName       string
+	Status       string
+	Encrypted    bool
+	AuthnRequest string
+	Response     string
+	// Assertion is the assertion before encryption, if it was encrypted.
+	Assertion  string
+	Signatures []DebugSignature
+}
+
+// DebugSignature describes an XML signature in the response.
+type DebugSignature struct {
+	Element          string
+	Reference        string
+	SignatureMethod  string
+	DigestMethod     string
+	Canonicalization string
+	Certificate      string
+	Fingerprint      string
+}
+
+// writeDebugPage shows the incoming AuthnRequest and the outgoing response
+// instead of auto-posting the form, with a button to continue to the SP.
+func (s *Server) writeDebugPage(w http.ResponseWriter, req *saml.IdpAuthnRequest, form *saml.IdpAuthnRequestForm) error {
+	responseBuf, err := base64.StdEncoding.DecodeString(form.SAMLResponse)
+	if err != nil {
+		return err
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(responseBuf); err != nil {
+		return err
+	}
+
+	data := DebugPageData{
+		Form:         form,
+		Binding:      req.ACSEndpoint.Binding,
+		SPName:       req.ServiceProviderMetadata.EntityID,
+		AuthnRequest: prettyXML(req.RequestBuffer),
+		Response:     prettyXML(responseBuf),
+		Status:       responseStatus(doc),
+		Signatures:   xmlSignatures(doc),
+	}
+
+	if doc.FindElement("//EncryptedAssertion") != nil && req.Assertion != nil {
+		data.Encrypted = true
+		assertionDoc := etree.NewDocument()
+		assertionDoc.SetRoot(req.Assertion.Element())
+		if buf, err := assertionDoc.WriteToBytes(); err == nil {
+			data.Assertion = prettyXML(buf)
+		}
+	}
+
+	renderTemplate(w, "debug.html", data)
+	return nil
+}
+
+// prettyXML indents an XML document for display, returning the input
+// unchanged if it can't be parsed.
+func prettyXML(buf []byte) string {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(buf); err != nil {
+		return string(buf)
+	}
+	doc.Indent(2)
+	out, err := doc.WriteToString()
+	if err != nil {
+		return string(buf)
+	}
+	return out
+}
+
+// xmlSignatures describes every Signature element in the document.
+func xmlSignatures(doc *etree.Document) []DebugSignature {
+	var signatures []DebugSignature
+	for _, sigEl := range doc.FindElements("//Signature") {
+		sig := DebugSignature{}
+		if parent := sigEl.Parent(); parent != nil {
+			sig.Element = parent.Tag
+			if id := parent.SelectAttrValue("ID", ""); id != "" {
+				sig.Element += " " + id
+			}
+		}
+		if el := sigEl.FindElement("SignedInfo/SignatureMethod"); el != nil {
+			sig.SignatureMethod = el.SelectAttrValue("Algorithm", "")
+		}
+		if el := sigEl.FindElement("SignedInfo/CanonicalizationMethod"); el != nil {
+			sig.Canonicalization = el.SelectAttrValue("Algorithm", "")
+		}
+		if el := sigEl.FindElement("SignedInfo/Reference"); el != nil {
+			sig.Reference = el.SelectAttrValue("URI", "")
+			if digestEl := el.FindElement("DigestMethod"); digestEl != nil {
+				sig.DigestMethod = digestEl.SelectAttrValue("Algorithm", "")
+			}
+		}
+		if el := sigEl.FindElement("KeyInfo/X509Data/X509Certificate"); el != nil {
+			certBytes, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(el.Text()), ""))
+			if err == nil {
+				if cert, err := x509.ParseCertificate(certBytes); err == nil {
+					sig.Certificate = cert.Subject.String()
+				}
+				sig.Fingerprint = fingerprint(certBytes)
+			}
+		}
+		signatures = append(signatures, sig)
+	}
+	return signatures
+}
+
+// fingerprint returns the colon-separated SHA-256 fingerprint of a certificate.
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,88 @@
+package idp
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+)
+
+func TestPrettyXML(t *testing.T) {
+	got := prettyXML([]byte(`<a><b c="1">text</b></a>`))
+	if got != "<a>\n  <b c=\"1\">text</b>\n</a>\n" {
+		t.Errorf("Unexpected pretty XML: %q", got)
+	}
+
+	if got := prettyXML([]byte("not xml <")); got != "not xml <" {
+		t.Errorf("Expected invalid XML unchanged, got %q", got)
+	}
+}
+
+func TestLoginFlowDebug(t *testing.T) {
+	server := testServer(t)
+
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}, "debug": {"1"}})
+	page := w.Body.String()
+
+	// The preview must not auto-submit, but carries the real response
+	if strings.Contains(page, "document.forms") || strings.Contains(page, ".submit()") {
+		t.Error("Expected debug page not to auto-submit the response")
+	}
+	for _, want := range []string{"Continue to SP", "https://sp.example.com/acs", "test-relay-state", "AuthnRequest", "id-test-request", "SHA-256 fingerprint", "urn:oasis:names:tc:SAML:2.0:status:Success"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected debug page to contain %q", want)
+		}
+	}
+
+	verifyResponse(t, server, decodeSAMLResponse(t, page))
+	if _, ok := server.sessionProvider.GetPendingRequest(requestID); ok {
+		t.Error("Expected pending request to be deleted")
+	}
+}
+
+func TestDebugStatusResponse(t *testing.T) {
+	server := testServer(t)
+	server.config.Server.Debug = true
+
+	// The debug option is checked by default on the login page
+	requestID := startSSO(t, server)
+	req := httptest.NewRequest("GET", "/login?request_id="+requestID, nil)
+	w := httptest.NewRecorder()
+	server.handleLogin(w, req)
+	if !strings.Contains(w.Body.String(), `name="debug" value="1" checked`) {
+		t.Error("Expected debug option to be checked when debug mode is enabled")
+	}
+
+	w = postAuthnRequest(server, `<samlp:NameIDPolicy Format="urn:example:nameid-format:custom"/>`)
+	page := w.Body.String()
+	if !strings.Contains(page, "Continue to SP") || !strings.Contains(page, "InvalidNameIDPolicy") {
+		t.Error("Expected status response on the debug page")
+	}
+}
+
+func TestXMLSignatures(t *testing.T) {
+	server := testServer(t)
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(decodeSAMLResponse(t, w.Body.String())); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	signatures := xmlSignatures(doc)
+	if len(signatures) == 0 {
+		t.Fatal("Expected signatures in the response")
+	}
+	for _, sig := range signatures {
+		if sig.SignatureMethod == "" || sig.DigestMethod == "" || sig.Reference == "" {
+			t.Errorf("Expected signature details, got %+v", sig)
+		}
+		if sig.Fingerprint != fingerprint(server.certificate.Raw) {
+			t.Errorf("Expected fingerprint of the IdP certificate, got %q", sig.Fingerprint)
+		}
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"time"
@@ -17,43 +17,49 @@ import (
 // handleMetadata serves the IDP metadata XML.
 func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 	// Build metadata with supported Name ID formats
-	metadata := s.idp.Metadata()
+	idp := s.requestIDP(r)
+	metadata := idp.Metadata()
+	metadata.EntityID = s.entityID(idp)
 
 	// Add all supported Name ID formats
 	for i := range metadata.IDPSSODescriptors {
-		metadata.IDPSSODescriptors[i].NameIDFormats = []saml.NameIDFormat{
-			saml.EmailAddressNameIDFormat,
-			saml.PersistentNameIDFormat,
-			saml.TransientNameIDFormat,
-			saml.UnspecifiedNameIDFormat,
-		}
+		metadata.IDPSSODescriptors[i].NameIDFormats = supportedNameIDFormats()
 	}
 
 	buf, err := xml.MarshalIndent(metadata, "", "  ")
 	if err != nil {
-		log.Printf("Error marshaling metadata: %v", err)
+		s.logger.Error("Error marshaling metadata", "err", err)
 		http.Error(w, "Failed to generate metadata", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/samlmetadata+xml")
 	if _, err := w.Write(buf); err != nil {
-		log.Printf("Error writing metadata: %v", err)
+		s.logger.Error("Error writing metadata", "err", err)
 	}
 }
 
 // handleSSO handles SAML SSO requests.
 func (s *Server) handleSSO(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
 	// Parse the SAML request
-	req, err := saml.NewIdpAuthnRequest(s.idp, r)
+	req, err := saml.NewIdpAuthnRequest(s.requestIDP(r), r)
 	if err != nil {
-		log.Printf("Error parsing SAML request: %v", err)
+		s.recordMalformedAuthnRequest(r, err)
+		s.logTransaction(nil, "", requestBinding(r), outcomeInvalidRequest, err.Error(), start)
+		s.notifySSOError(nil, outcomeInvalidRequest, err.Error())
 		http.Error(w, "Invalid SAML request", http.StatusBadRequest)
 		return
 	}
 
-	if err := req.Validate(); err != nil {
-		log.Printf("Error validating SAML request: %v", err)
+	// Validate against the server's clock, which may be fixed or skewed
+	req.Now = s.clock.Now()
+	err = req.Validate()
+	s.recordAuthnRequest(r, req, err)
+	if err != nil {
+		s.logTransaction(req, "", requestBinding(r), outcomeInvalidRequest, err.Error(), start)
+		s.notifySSOError(req, outcomeInvalidRequest, err.Error())
 		http.Error(w, "Invalid SAML request", http.StatusBadRequest)
 		return
 	}
@@ -61,18 +67,34 @@ func (s *Server) handleSSO(w http.ResponseWriter, r *http.Request) {
 	// Get SP config
 	spConfig := s.spProvider.GetServiceProviderConfig(req.ServiceProviderMetadata.EntityID)
 	if spConfig == nil {
-		log.Printf("Unknown service provider: %s", req.ServiceProviderMetadata.EntityID)
+		s.logTransaction(req, "", requestBinding(r), outcomeInvalidRequest, "Unknown service provider", start)
+		s.notifySSOError(req, outcomeInvalidRequest, "Unknown service provider")
 		http.Error(w, "Unknown service provider", http.StatusBadRequest)
 		return
 	}
 
+	// Reject NameID formats we can never supply before showing the login page
+	if policy := req.Request.NameIDPolicy; policy != nil && policy.Format != nil && *policy.Format != "" {
+		if !isSupportedNameIDFormat(saml.NameIDFormat(*policy.Format)) {
+			message := fmt.Sprintf("Unsupported NameID format %s", *policy.Format)
+			s.notifySSOError(req, saml.StatusInvalidNameIDPolicy, message)
+			if err := s.writeStatusResponse(w, req, nil, saml.StatusRequester, saml.StatusInvalidNameIDPolicy, message); err != nil {
+				s.requestLogger(req, "").Error("Error writing InvalidNameIDPolicy response", "err", err)
+				http.Error(w, "Failed to send response", http.StatusInternalServerError)
+				return
+			}
+			s.logTransaction(req, "", req.ACSEndpoint.Binding, saml.StatusInvalidNameIDPolicy, message, start)
+			return
+		}
+	}
+
 	// Store pending request and redirect to login
 	// Always show login page - no session persistence for test IDP
-	requestID := randomHex(16)
+	requestID := s.ids.HexID(32)
 	s.sessionProvider.StorePendingRequest(requestID, req, spConfig)
 
 	// Redirect to login page
-	loginURL := fmt.Sprintf("/login?request_id=%s", url.QueryEscape(requestID))
+	loginURL := fmt.Sprintf("%s?request_id=%s", s.path("/login"), url.QueryEscape(requestID))
 	http.Redirect(w, r, loginURL, http.StatusFound)
 }
 
@@ -108,30 +130,61 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 // showLoginPage renders the login page with user dropdown.
 func (s *Server) showLoginPage(w http.ResponseWriter, r *http.Request, requestID string, pendingSession *SessionData) {
-	tmpl, err := template.ParseFS(web.Assets, "templates/login.html")
+	data := LoginPageData{
+		RequestID:           requestID,
+		SPName:              pendingSession.SP.EntityID,
+		Users:               pendingSession.SP.Users,
+		AllowCustomIdentity: pendingSession.SP.AllowCustomIdentity,
+		NameIDFormats:       nameIDFormatNames(),
+		DefaultNameIDFormat: pendingSession.SP.NameIDFormat,
+		Debug:               s.config.Server.Debug,
+		ClockOffset:         pendingSession.SP.ClockOffset,
+		ReleasedAttributes:  make(map[string][]string, len(pendingSession.SP.Users)),
+	}
+
+	// Preview the attributes each user would send after the release policy
+	for i := range pendingSession.SP.Users {
+		user := &pendingSession.SP.Users[i]
+		names, err := s.releasedAttributeNames(pendingSession, user)
+		if err != nil {
+			s.requestLogger(pendingSession.SAMLRequest, user.Name).Error("Error previewing attributes", "err", err)
+			continue
+		}
+		data.ReleasedAttributes[user.Name] = names
+	}
+
+	renderTemplate(w, "login.html", data)
+}
+
+// renderTemplate renders an embedded page template together with the shared styles.
+func renderTemplate(w http.ResponseWriter, name string, data interface{}) {
+	tmpl, err := template.ParseFS(web.Assets, "templates/"+name, "templates/styles.html")
 	if err != nil {
-		log.Printf("Error parsing template: %v", err)
+		slog.Error("Error parsing template", "template", name, "err", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	data := LoginPageData{
-		RequestID: requestID,
-		SPName:    pendingSession.SP.EntityID,
-		Users:     pendingSession.SP.Users,
-	}
-
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := tmpl.Execute(w, data); err != nil {
-		log.Printf("Error executing template: %v", err)
+		slog.Error("Error executing template", "template", name, "err", err)
 	}
 }
 
 // LoginPageData holds data for the login template.
 type LoginPageData struct {
-	RequestID string
-	SPName    string
-	Users     []config.User
+	RequestID           string
+	SPName              string
+	Users               []config.User
+	AllowCustomIdentity bool
+	NameIDFormats       []string
+	DefaultNameIDFormat string
+	// Debug checks the response preview option by default.
+	Debug bool
+	// ClockOffset is the SP's configured clock offset, if any.
+	ClockOffset time.Duration
+	// ReleasedAttributes lists the attributes sent for each user, by name.
+	ReleasedAttributes map[string][]string
 }
 
 // processLogin handles user selection and creates SAML response.
@@ -141,6 +194,31 @@ func (s *Server) processLogin(w http.ResponseWriter, r *http.Request, requestID
 		return
 	}
 
+	pendingSession.Debug = r.FormValue("debug") != ""
+	offset, err := parseClockOffset(r.FormValue("clock_offset"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pendingSession.ClockOffset = offset
+
+	// Ad-hoc identity entered on the login page
+	if r.FormValue("identity") == "custom" {
+		if !pendingSession.SP.AllowCustomIdentity {
+			http.Error(w, "Custom identities are not enabled for this service provider", http.StatusForbidden)
+			return
+		}
+		user, err := customIdentityFromForm(r.Form)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid custom identity: %v", err), http.StatusBadRequest)
+			return
+		}
+		pendingSession.UserName = user.Name
+		pendingSession.CustomIdentity = true
+		s.completeLogin(w, r, requestID, pendingSession, user, config.MFANone)
+		return
+	}
+
 	userName := r.FormValue("user")
 	if userName == "" {
 		http.Error(w, "No user selected", http.StatusBadRequest)
@@ -153,41 +231,328 @@ func (s *Server) processLogin(w http.ResponseWriter, r *http.Request, requestID
 		http.Error(w, "Invalid user", http.StatusBadRequest)
 		return
 	}
+	pendingSession.UserName = user.Name
+
+	// Challenge for a second factor before issuing the assertion
+	if method := pendingSession.SP.MFAMethod(user); method != config.MFANone {
+		pendingSession.MFAUser = user.Name
+		s.showMFAPage(w, requestID, user, method, "")
+		return
+	}
+
+	s.completeLogin(w, r, requestID, pendingSession, user, config.MFANone)
+}
+
+// MFAPageData holds data for the MFA challenge template.
+type MFAPageData struct {
+	RequestID string
+	User      *config.User
+	Method    string
+	Error     string
+}
+
+// showMFAPage renders the TOTP or push approval challenge for a user.
+func (s *Server) showMFAPage(w http.ResponseWriter, requestID string, user *config.User, method, errMsg string) {
+	renderTemplate(w, "mfa.html", MFAPageData{
+		RequestID: requestID,
+		User:      user,
+		Method:    method,
+		Error:     errMsg,
+	})
+}
+
+// handleMFA verifies the second factor for the user who passed the login
+// step. The form's user must match them, so an assertion can't be issued
+// for a different user of the SP.
+func (s *Server) handleMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID := r.URL.Query().Get("request_id")
+	if requestID == "" {
+		http.Error(w, "Missing request_id", http.StatusBadRequest)
+		return
+	}
+
+	pendingSession, ok := s.sessionProvider.GetPendingRequest(requestID)
+	if !ok {
+		http.Error(w, "Invalid or expired request", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	user := pendingSession.SP.GetUserByName(pendingSession.MFAUser)
+	if user == nil {
+		http.Error(w, "No login awaiting a second factor", http.StatusBadRequest)
+		return
+	}
+	if name := r.FormValue("user"); name != "" && name != user.Name {
+		http.Error(w, "User does not match the login", http.StatusBadRequest)
+		return
+	}
+
+	method := pendingSession.SP.MFAMethod(user)
+	if method == config.MFANone {
+		http.Error(w, "MFA not required for user", http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("action") == "deny" {
+		s.failLogin(w, requestID, pendingSession, saml.StatusResponder, saml.StatusAuthnFailed, "User denied the authentication request")
+		return
+	}
+
+	switch method {
+	case config.MFATOTP:
+		valid, err := verifyTOTP(user.TOTPSecret, r.FormValue("code"), time.Now())
+		if err != nil {
+			s.requestLogger(pendingSession.SAMLRequest, user.Name).Error("Error verifying TOTP", "err", err)
+			http.Error(w, "Invalid TOTP configuration", http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			s.showMFAPage(w, requestID, user, method, "Invalid verification code. Please try again.")
+			return
+		}
+	case config.MFAPush:
+		if r.FormValue("action") != "approve" {
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.completeLogin(w, r, requestID, pendingSession, user, method)
+}
+
+// failLogin sends an error status response to the SP and discards the pending request.
+func (s *Server) failLogin(w http.ResponseWriter, requestID string, pendingSession *SessionData, topLevel, secondLevel, message string) {
+	if err := s.writeStatusResponse(w, pendingSession.SAMLRequest, pendingSession, topLevel, secondLevel, message); err != nil {
+		s.requestLogger(pendingSession.SAMLRequest, pendingSession.UserName).Error("Error writing status response", "status", secondLevel, "err", err)
+		http.Error(w, "Failed to send response", http.StatusInternalServerError)
+		return
+	}
+	s.logTransaction(pendingSession.SAMLRequest, pendingSession.UserName, pendingSession.SAMLRequest.ACSEndpoint.Binding, secondLevel, message, pendingSession.CreateTime)
+	s.notifyResponse(config.WebhookEventLoginFailure, pendingSession.SAMLRequest, pendingSession.UserName, secondLevel, message)
+
+	s.sessionProvider.DeletePendingRequest(requestID)
+}
+
+// completeLogin issues the SAML response for an authenticated user.
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, requestID string, pendingSession *SessionData, user *config.User, mfaMethod string) {
+	// Templated attributes and the response are issued at the same time
+	now := s.loginTime(pendingSession)
+	customAttributes, subjectID, err := s.loginAttributes(pendingSession, user, mfaMethod, now, s.ids)
+	if err != nil {
+		s.requestLogger(pendingSession.SAMLRequest, user.Name).Error("Error rendering attributes", "err", err)
+		http.Error(w, fmt.Sprintf("Failed to render attributes: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Honour the NameIDPolicy from the AuthnRequest
+	policy := pendingSession.SAMLRequest.Request.NameIDPolicy
+	nameIDFormat, err := resolveNameIDFormat(policy, pendingSession.SP, user)
+	if err != nil {
+		s.failLogin(w, requestID, pendingSession, saml.StatusRequester, saml.StatusInvalidNameIDPolicy, err.Error())
+		return
+	}
+
+	qualifier := spNameQualifier(policy, pendingSession.SP.EntityID)
+	nameID := s.nameIDValue(user, qualifier, nameIDFormat)
+
+	// AllowCreate="false" only permits persistent identifiers issued before
+	if nameIDFormat == saml.PersistentNameIDFormat && generatesNameID(user, nameIDFormat) {
+		allowCreate := policy == nil || policy.AllowCreate == nil || *policy.AllowCreate
+		if !s.persistentIDs.issue(nameID, allowCreate) {
+			s.failLogin(w, requestID, pendingSession, saml.StatusRequester, saml.StatusInvalidNameIDPolicy, "No persistent identifier exists and AllowCreate is false")
+			return
+		}
+	}
 
 	// Build SAML session for response (no persistent session - always show login)
-	sessionID := randomHex(32)
+	pendingSession.SAMLRequest.Now = now
+	sessionID := s.ids.HexID(32)
 	samlSession := &saml.Session{
 		ID:               sessionID,
-		CreateTime:       time.Now(),
-		ExpireTime:       time.Now().Add(5 * time.Minute), // Short-lived for response only
+		CreateTime:       now,
+		ExpireTime:       now.Add(5 * time.Minute), // Short-lived for response only
 		Index:            sessionID,
-		NameID:           user.NameID,
-		NameIDFormat:     string(GetNameIDFormat(pendingSession.SP.NameIDFormat)),
-		SubjectID:        user.NameID,
+		NameID:           nameID,
+		NameIDFormat:     string(nameIDFormat),
+		SubjectID:        subjectID,
 		UserName:         user.Name,
-		CustomAttributes: buildCustomAttributes(user),
+		CustomAttributes: customAttributes,
+	}
+
+	maker := assertionMaker{
+		issuer:               s.entityID(pendingSession.SAMLRequest.IDP),
+		assertionID:          fmt.Sprintf("id-%s", s.ids.HexID(40)),
+		authnContextClassRef: authnContextForMFA(mfaMethod),
+		spNameQualifier:      qualifier,
+		release:              s.spProvider.releasePolicy(pendingSession.SP.EntityID, pendingSession.SAMLRequest),
+		timing:               newAssertionTiming(pendingSession.SP, s.clockOffset(pendingSession.SAMLRequest, pendingSession)),
+		omitAddress:          s.deterministic,
+	}
+
+	// Ask the user to review the assertion before it is sent
+	if pendingSession.SP.Consent {
+		if err := maker.MakeAssertion(pendingSession.SAMLRequest, samlSession); err != nil {
+			s.requestLogger(pendingSession.SAMLRequest, pendingSession.UserName).Error("Error making assertion", "err", err)
+			http.Error(w, "Failed to create assertion", http.StatusInternalServerError)
+			return
+		}
+		pendingSession.AwaitingConsent = true
+		s.showConsentPage(w, requestID, pendingSession, user)
+		return
 	}
 
 	// Create and send SAML response
-	s.createAndSendResponse(w, r, pendingSession.SAMLRequest, samlSession)
+	s.createAndSendResponse(w, r, pendingSession, samlSession, maker)
 
 	// Clean up pending request
 	s.sessionProvider.DeletePendingRequest(requestID)
 }
 
+// loginTime returns the time a login's response is issued at: the server's
+// clock with the SP's (or the login page's) clock offset applied.
+func (s *Server) loginTime(pendingSession *SessionData) time.Time {
+	return s.clock.Now().Add(s.clockOffset(pendingSession.SAMLRequest, pendingSession))
+}
+
+// loginAttributes builds the attributes sent for a user, before the release
+// policy is applied, and the value of the library's subject-id attribute.
+// Templated values are evaluated at now, drawing uuids from ids.
+func (s *Server) loginAttributes(pendingSession *SessionData, user *config.User, mfaMethod string, now time.Time, ids IDSource) ([]saml.Attribute, string, error) {
+	// Evaluate templated attribute values for this login. Values typed into
+	// the custom identity form are literals: as templates they could read
+	// other users' settings through .SP
+	rendered := user
+	if !pendingSession.CustomIdentity {
+		var err error
+		rendered, err = renderUserAttributes(user, &AttributeTemplateData{
+			SP:        pendingSession.SP,
+			RequestID: pendingSession.SAMLRequest.Request.ID,
+			Now:       now,
+			ids:       ids,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	customAttributes := buildCustomAttributes(rendered)
+	if amr := amrAttribute(mfaMethod); amr != nil {
+		customAttributes = append(customAttributes, *amr)
+	}
+	customAttributes = applyAttributeProfile(AttributeProfiles[pendingSession.SP.AttributeProfile], customAttributes, profileContext{
+		User:        rendered,
+		IDPEntityID: s.entityID(pendingSession.SAMLRequest.IDP),
+	})
+
+	// Send subject identifier attributes if the SP requires them; otherwise
+	// keep sending the name_id as subject-id
+	subjectID := user.NameID
+	if req := s.spProvider.SubjectIDReq(pendingSession.SP.EntityID); req != "" {
+		subjectID = ""
+		customAttributes = append(customAttributes, s.subjectIdentifierAttributes(user, pendingSession.SP, req)...)
+	}
+
+	return customAttributes, subjectID, nil
+}
+
+// releasedAttributeNames returns the names of the attributes that would be
+// sent to the SP for a user, after the release policy is applied. Templates
+// draw uuids from a throwaway source, so showing the login page doesn't use
+// up seeded IDs.
+func (s *Server) releasedAttributeNames(pendingSession *SessionData, user *config.User) ([]string, error) {
+	customAttributes, subjectID, err := s.loginAttributes(pendingSession, user, pendingSession.SP.MFAMethod(user), s.loginTime(pendingSession), CryptoIDSource())
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the assertion on a copy of the request, as for a real login
+	req := *pendingSession.SAMLRequest
+	maker := assertionMaker{release: s.spProvider.releasePolicy(pendingSession.SP.EntityID, &req)}
+	if err := maker.MakeAssertion(&req, &saml.Session{
+		NameID:           user.NameID,
+		SubjectID:        subjectID,
+		UserName:         user.Name,
+		CustomAttributes: customAttributes,
+	}); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, stmt := range req.Assertion.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+				names = append(names, fmt.Sprintf("%s (%s)", attr.FriendlyName, attr.Name))
+			} else {
+				names = append(names, attr.Name)
+			}
+		}
+	}
+	return names, nil
+}
+
 // createAndSendResponse creates a SAML response and sends it to the SP.
-func (s *Server) createAndSendResponse(w http.ResponseWriter, r *http.Request, req *saml.IdpAuthnRequest, session *saml.Session) {
-	// Use the default assertion maker to create the assertion
-	assertionMaker := saml.DefaultAssertionMaker{}
-	if err := assertionMaker.MakeAssertion(req, session); err != nil {
-		log.Printf("Error making assertion: %v", err)
+func (s *Server) createAndSendResponse(w http.ResponseWriter, r *http.Request, pendingSession *SessionData, session *saml.Session, assertionMaker assertionMaker) {
+	if err := assertionMaker.MakeAssertion(pendingSession.SAMLRequest, session); err != nil {
+		s.requestLogger(pendingSession.SAMLRequest, pendingSession.UserName).Error("Error making assertion", "err", err)
 		http.Error(w, "Failed to create assertion", http.StatusInternalServerError)
 		return
 	}
 
+	s.sendAssertion(w, pendingSession)
+}
+
+// sendAssertion signs the pending request's assertion and sends the
+// response to the SP, or shows it on the debug page first.
+func (s *Server) sendAssertion(w http.ResponseWriter, pendingSession *SessionData) {
+	req := pendingSession.SAMLRequest
+
+	// Sign the assertion ourselves so attribute value types are preserved
+	if err := s.makeAssertionEl(req); err != nil {
+		s.requestLogger(req, pendingSession.UserName).Error("Error signing assertion", "err", err)
+		http.Error(w, "Failed to sign assertion", http.StatusInternalServerError)
+		return
+	}
+
+	// Build the response ourselves so its ID and time come from the server
+	responseEl, err := s.makeResponse(req, saml.Status{StatusCode: saml.StatusCode{Value: saml.StatusSuccess}}, req.AssertionEl)
+	if err != nil {
+		s.requestLogger(req, pendingSession.UserName).Error("Error making response", "err", err)
+		http.Error(w, "Failed to send response", http.StatusInternalServerError)
+		return
+	}
+	req.ResponseEl = responseEl
+
+	form, err := req.PostBinding()
+	if err != nil {
+		s.requestLogger(req, pendingSession.UserName).Error("Error making response", "err", err)
+		http.Error(w, "Failed to send response", http.StatusInternalServerError)
+		return
+	}
+	s.recordResponse(req, &form, pendingSession.UserName)
+	s.logTransaction(req, pendingSession.UserName, req.ACSEndpoint.Binding, outcomeSuccess, "", pendingSession.CreateTime)
+	s.notifyResponse(config.WebhookEventLoginSuccess, req, pendingSession.UserName, outcomeSuccess, "")
+
+	if pendingSession.Debug {
+		if err := s.writeDebugPage(w, req, &form); err != nil {
+			s.requestLogger(req, pendingSession.UserName).Error("Error writing debug page", "err", err)
+			http.Error(w, "Failed to send response", http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// Write the response using the library's built-in method
 	if err := req.WriteResponse(w); err != nil {
-		log.Printf("Error writing response: %v", err)
+		s.requestLogger(req, pendingSession.UserName).Error("Error writing response", "err", err)
 		http.Error(w, "Failed to send response", http.StatusInternalServerError)
 		return
 	}
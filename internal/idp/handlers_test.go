@@ -1,26 +1,39 @@
 package idp
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
 )
 
 // testServer creates a minimal server for testing handlers
 func testServer(t *testing.T) *Server {
 	t.Helper()
+	return testServerAt(t, "http://localhost:8080")
+}
+
+// testServerAt is testServer with a different base URL.
+func testServerAt(t *testing.T, baseURL string) *Server {
+	t.Helper()
 
 	cfg := &config.Config{
 		Server: config.ServerConfig{
 			Host:    "localhost",
 			Port:    8080,
-			BaseURL: "http://localhost:8080",
+			BaseURL: baseURL,
 		},
 		IDP: config.IDPConfig{
-			EntityID:        "http://localhost:8080/metadata",
 			CertificatePath: "../../testdata/test.crt",
 			PrivateKeyPath:  "../../testdata/test.key",
 		},
@@ -46,10 +59,135 @@ func testServer(t *testing.T) *Server {
 	if err != nil {
 		t.Fatalf("Failed to create server: %v", err)
 	}
+	t.Cleanup(func() { server.Close() })
 
 	return server
 }
 
+// startSSO posts an AuthnRequest from the test SP to /sso and returns the
+// request ID of the resulting pending login.
+func startSSO(t *testing.T, server *Server) string {
+	t.Helper()
+	return startSSOWithPolicy(t, server, "")
+}
+
+// startSSOWithPolicy is startSSO with extra elements (e.g. a NameIDPolicy)
+// added to the AuthnRequest.
+func startSSOWithPolicy(t *testing.T, server *Server, extra string) string {
+	t.Helper()
+
+	w := postAuthnRequest(server, extra)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected status 302 from /sso, got %d: %s", resp.StatusCode, w.Body.String())
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid redirect location: %v", err)
+	}
+	return location.Query().Get("request_id")
+}
+
+// postAuthnRequest posts an AuthnRequest from the test SP to /sso, with
+// extra inserted after the Issuer element (e.g. a NameIDPolicy).
+func postAuthnRequest(server *Server, extra string) *httptest.ResponseRecorder {
+	authnRequest := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" `+
+		`xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-test-request" Version="2.0" `+
+		`IssueInstant="%s" AssertionConsumerServiceURL="https://sp.example.com/acs">`+
+		`<saml:Issuer>https://sp.example.com</saml:Issuer>%s</samlp:AuthnRequest>`,
+		time.Now().UTC().Format(time.RFC3339), extra)
+
+	form := url.Values{}
+	form.Set("SAMLRequest", base64.StdEncoding.EncodeToString([]byte(authnRequest)))
+	form.Set("RelayState", "test-relay-state")
+
+	req := httptest.NewRequest("POST", "/sso", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	server.handleSSO(w, req)
+	return w
+}
+
+// postForm submits form values to a handler and returns the recorder.
+func postForm(server *Server, handler http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+var samlResponseRe = regexp.MustCompile(`name="SAMLResponse" value="([^"]+)"`)
+
+// decodeSAMLResponse extracts and decodes the SAMLResponse from an auto-POST form.
+func decodeSAMLResponse(t *testing.T, body string) string {
+	t.Helper()
+
+	m := samlResponseRe.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("No SAMLResponse found in body: %s", body)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(html.UnescapeString(m[1]))
+	if err != nil {
+		t.Fatalf("Failed to decode SAMLResponse: %v", err)
+	}
+	return string(decoded)
+}
+
+// verifyResponse validates a decoded SAMLResponse as the test SP would,
+// including its signatures, and returns the assertion.
+func verifyResponse(t *testing.T, server *Server, response string) *saml.Assertion {
+	t.Helper()
+
+	acsURL, _ := url.Parse("https://sp.example.com/acs")
+	metadata := server.idp.Metadata()
+	metadata.EntityID = server.entityID(server.idp)
+	sp := saml.ServiceProvider{
+		EntityID:    "https://sp.example.com",
+		AcsURL:      *acsURL,
+		IDPMetadata: metadata,
+	}
+
+	assertion, err := sp.ParseXMLResponse([]byte(response), []string{"id-test-request"}, *acsURL)
+	if err != nil {
+		var invalidErr *saml.InvalidResponseError
+		if errors.As(err, &invalidErr) {
+			err = invalidErr.PrivateErr
+		}
+		t.Fatalf("Response failed SP validation: %v", err)
+	}
+	return assertion
+}
+
+func TestLoginFlow(t *testing.T) {
+	server := testServer(t)
+	requestID := startSSO(t, server)
+
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	response := decodeSAMLResponse(t, w.Body.String())
+	if !strings.Contains(response, "urn:oasis:names:tc:SAML:2.0:status:Success") {
+		t.Error("Expected Success status in response")
+	}
+	if !strings.Contains(response, PasswordProtectedTransportAuthnContext) {
+		t.Error("Expected PasswordProtectedTransport authn context")
+	}
+
+	assertion := verifyResponse(t, server, response)
+	if assertion.Subject.NameID.Value != "test@example.com" {
+		t.Errorf("Expected NameID 'test@example.com', got '%s'", assertion.Subject.NameID.Value)
+	}
+
+	if _, ok := server.sessionProvider.GetPendingRequest(requestID); ok {
+		t.Error("Expected pending request to be deleted after login")
+	}
+}
+
 func TestHandleMetadata(t *testing.T) {
 	server := testServer(t)
 
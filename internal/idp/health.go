@@ -0,0 +1,179 @@
+package idp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// Health check statuses. A warning doesn't make the server unready.
+const (
+	HealthOK   = "ok"
+	HealthWarn = "warn"
+	HealthFail = "fail"
+)
+
+// HealthReport is the JSON body of /healthz and /readyz.
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the result of a single readiness check.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Tenant  string `json:"tenant,omitempty"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// handleHealthz reports that the process is up.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, HealthReport{Status: HealthOK})
+}
+
+// handleReadyz runs the readiness checks, responding 503 if any failed.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, s.readiness(time.Now()))
+}
+
+// HealthHandlers returns root-level /healthz and /readyz handlers for all
+// servers. /readyz runs every tenant's readiness checks.
+func HealthHandlers(servers []*Server) (healthz, readyz http.Handler) {
+	healthz = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, HealthReport{Status: HealthOK})
+	})
+	readyz = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		var checks []HealthCheck
+		for _, server := range servers {
+			checks = append(checks, server.readiness(now).Checks...)
+		}
+		writeHealthReport(w, newHealthReport(checks))
+	})
+	return healthz, readyz
+}
+
+// ServesRootHealth reports whether the server's own /healthz and /readyz
+// are at the root of the listener.
+func (s *Server) ServesRootHealth() bool {
+	return s.pattern("/healthz") == "/healthz"
+}
+
+// readiness checks the signing key, certificate and SP metadata.
+func (s *Server) readiness(now time.Time) HealthReport {
+	checks := []HealthCheck{
+		s.checkSigningKey(),
+		s.checkCertificate(now),
+		s.checkSPMetadata(),
+	}
+	for i := range checks {
+		checks[i].Tenant = s.tenant
+	}
+	return newHealthReport(checks)
+}
+
+// newHealthReport reports the worst status of the checks.
+func newHealthReport(checks []HealthCheck) HealthReport {
+	report := HealthReport{Status: HealthOK, Checks: checks}
+	for _, check := range checks {
+		switch {
+		case check.Status == HealthFail:
+			report.Status = HealthFail
+		case check.Status == HealthWarn && report.Status == HealthOK:
+			report.Status = HealthWarn
+		}
+	}
+	return report
+}
+
+// checkSigningKey signs a test digest and verifies it with the certificate,
+// so a key that doesn't match the certificate fails.
+func (s *Server) checkSigningKey() HealthCheck {
+	check := HealthCheck{Name: "signing_key", Status: HealthOK}
+
+	digest := sha256.Sum256([]byte("saml-test-idp readiness"))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		check.Status, check.Message = HealthFail, fmt.Sprintf("cannot sign: %v", err)
+		return check
+	}
+
+	publicKey, ok := s.certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		check.Status, check.Message = HealthFail, "certificate does not hold an RSA public key"
+		return check
+	}
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		check.Status, check.Message = HealthFail, "private key does not match the certificate"
+	}
+	return check
+}
+
+// checkCertificate fails if the signing certificate isn't valid now and
+// warns if it expires within the configured warning period.
+func (s *Server) checkCertificate(now time.Time) HealthCheck {
+	check := HealthCheck{Name: "certificate", Status: HealthOK}
+	cert := s.certificate
+
+	switch {
+	case now.Before(cert.NotBefore):
+		check.Status = HealthFail
+		check.Message = "not valid until " + cert.NotBefore.UTC().Format(time.RFC3339)
+	case now.After(cert.NotAfter):
+		check.Status = HealthFail
+		check.Message = "expired " + cert.NotAfter.UTC().Format(time.RFC3339)
+	case cert.NotAfter.Sub(now) < s.config.Health.CertificateWarningPeriod():
+		check.Status = HealthWarn
+		check.Message = fmt.Sprintf("expires %s (in %d days)", cert.NotAfter.UTC().Format(time.RFC3339), int(cert.NotAfter.Sub(now).Hours()/24))
+	default:
+		check.Message = "expires " + cert.NotAfter.UTC().Format(time.RFC3339)
+	}
+	return check
+}
+
+// checkSPMetadata fails if a configured SP has no metadata with an
+// AssertionConsumerService.
+func (s *Server) checkSPMetadata() HealthCheck {
+	check := HealthCheck{Name: "sp_metadata", Status: HealthOK}
+
+	for i := range s.config.ServiceProviders {
+		entityID := s.config.ServiceProviders[i].EntityID
+		metadata, err := s.spProvider.GetServiceProvider(nil, entityID)
+		if err != nil || !hasACSEndpoint(metadata.SPSSODescriptors) {
+			check.Status, check.Message = HealthFail, fmt.Sprintf("%s has no usable metadata", entityID)
+			return check
+		}
+	}
+	check.Message = fmt.Sprintf("%d service provider(s) loaded", len(s.config.ServiceProviders))
+	return check
+}
+
+func writeHealthReport(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == HealthFail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+}
+
+// hasACSEndpoint reports whether any SP descriptor has an
+// AssertionConsumerService to send responses to.
+func hasACSEndpoint(descriptors []saml.SPSSODescriptor) bool {
+	for _, descriptor := range descriptors {
+		if len(descriptor.AssertionConsumerServices) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package idp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// checkStatus returns the status of the named check in a report.
+func checkStatus(report HealthReport, name string) string {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check.Status
+		}
+	}
+	return ""
+}
+
+func TestHealthz(t *testing.T) {
+	server := testServer(t)
+	w := httptest.NewRecorder()
+	server.handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	var report HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.Status != HealthOK {
+		t.Errorf("Expected ok report, got %s (%v)", w.Body.String(), err)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	server := testServer(t)
+	now := server.certificate.NotBefore.Add(time.Hour)
+
+	report := server.readiness(now)
+	if report.Status != HealthOK {
+		t.Errorf("Expected ok, got %+v", report)
+	}
+	for _, name := range []string{"signing_key", "certificate", "sp_metadata"} {
+		if status := checkStatus(report, name); status != HealthOK {
+			t.Errorf("Expected %s check to be ok, got %q", name, status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	server.handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK && w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected status %d", w.Code)
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected JSON, got %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestReadyzCertificateExpiry(t *testing.T) {
+	server := testServer(t)
+	notAfter := server.certificate.NotAfter
+
+	report := server.readiness(notAfter.Add(-10 * 24 * time.Hour))
+	if report.Status != HealthWarn || checkStatus(report, "certificate") != HealthWarn {
+		t.Errorf("Expected a warning 10 days before expiry, got %+v", report)
+	}
+
+	server.config.Health.CertificateWarningDays = 5
+	if report := server.readiness(notAfter.Add(-10 * 24 * time.Hour)); report.Status != HealthOK {
+		t.Errorf("Expected no warning outside a 5 day period, got %+v", report)
+	}
+
+	if report := server.readiness(notAfter.Add(time.Hour)); report.Status != HealthFail {
+		t.Errorf("Expected failure after expiry, got %+v", report)
+	}
+}
+
+func TestReadyzSigningKeyMismatch(t *testing.T) {
+	server := testServer(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	server.privateKey = key
+
+	report := server.readiness(server.certificate.NotBefore.Add(time.Hour))
+	if report.Status != HealthFail || checkStatus(report, "signing_key") != HealthFail {
+		t.Errorf("Expected signing_key failure, got %+v", report)
+	}
+}
+
+func TestReadyzSPMetadata(t *testing.T) {
+	server := testServer(t)
+	entry := server.spProvider.sps["https://sp.example.com"]
+	entry.Metadata = &saml.EntityDescriptor{EntityID: "https://sp.example.com"}
+
+	w := httptest.NewRecorder()
+	server.handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+
+	var report HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if checkStatus(report, "sp_metadata") != HealthFail {
+		t.Errorf("Expected sp_metadata failure, got %+v", report)
+	}
+}
+
+func TestHealthHandlersTenants(t *testing.T) {
+	servers, _ := tenantServers(t, map[string]string{
+		"acme":   "http://localhost:8080/acme",
+		"globex": "http://localhost:8080/globex",
+	})
+	if servers[0].ServesRootHealth() {
+		t.Error("Expected a tenant under a path not to serve /healthz at the root")
+	}
+	if !testServer(t).ServesRootHealth() {
+		t.Error("Expected a server without a base path to serve /healthz at the root")
+	}
+
+	healthz, readyz := HealthHandlers(servers)
+	w := httptest.NewRecorder()
+	healthz.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 from /healthz, got %d", w.Code)
+	}
+
+	// A failing tenant makes the whole process unready
+	servers[1].spProvider.sps["https://globex.example.com"].Metadata = &saml.EntityDescriptor{}
+	w = httptest.NewRecorder()
+	readyz.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 from /readyz, got %d", w.Code)
+	}
+
+	var report HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	tenants := map[string]string{}
+	for _, check := range report.Checks {
+		if check.Name == "sp_metadata" {
+			tenants[check.Tenant] = check.Status
+		}
+	}
+	if tenants["acme"] != HealthOK || tenants["globex"] != HealthFail {
+		t.Errorf("Expected per-tenant sp_metadata checks, got %+v", report.Checks)
+	}
+}
@@ -0,0 +1,394 @@
+package idp
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+)
+
+// DefaultHistorySize is the number of history entries kept when the
+// configuration doesn't set one.
+const DefaultHistorySize = 100
+
+// History entry types.
+const (
+	HistoryAuthnRequest = "AuthnRequest"
+	HistoryResponse     = "Response"
+)
+
+// HistoryEntry records an AuthnRequest received or a Response sent.
+type HistoryEntry struct {
+	ID          uint64    `json:"id"`
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	SP          string    `json:"sp"`
+	User        string    `json:"user,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"`
+	RelayState  string    `json:"relay_state,omitempty"`
+	Binding     string    `json:"binding,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	ForceAuthn  bool      `json:"force_authn,omitempty"`
+	IsPassive   bool      `json:"is_passive,omitempty"`
+	XML         string    `json:"xml"`
+}
+
+// History is a bounded in-memory log of requests and responses, optionally
+// appended to a JSONL file.
+type History struct {
+	mu   sync.Mutex
+	size int
+	// entries is a ring buffer of up to size entries, the oldest at start
+	entries []HistoryEntry
+	start   int
+	nextID  uint64
+
+	path string
+	file *os.File
+	// fileLines counts the lines in the file, which is compacted to the
+	// last size entries when it reaches twice that
+	fileLines int
+}
+
+// NewHistory creates a history holding up to size entries. If path is set,
+// the most recent entries are loaded from it and new ones are appended.
+func NewHistory(size int, path string) (*History, error) {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	h := &History{size: size, nextID: 1, path: path}
+	if path == "" {
+		return h, nil
+	}
+
+	entries, lines, err := readHistoryFile(path, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load history file: %w", err)
+	}
+	for _, entry := range entries {
+		h.append(entry)
+		if entry.ID >= h.nextID {
+			h.nextID = entry.ID + 1
+		}
+	}
+
+	// Drop older and unreadable entries from the file
+	if lines > len(entries) {
+		if err := h.rewrite(entries); err != nil {
+			return nil, fmt.Errorf("failed to compact history file: %w", err)
+		}
+		return h, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	h.file = file
+	h.fileLines = lines
+	return h, nil
+}
+
+// readHistoryFile returns the last n entries of a JSONL history file and
+// the number of lines in it. Lines that can't be parsed are skipped.
+func readHistoryFile(path string, n int) ([]HistoryEntry, int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	ring := &History{size: n}
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines++
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Warn("Skipping unreadable history entry", "file", path, "line", lines, "err", err)
+			continue
+		}
+		ring.append(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	return ring.ordered(), lines, nil
+}
+
+// compact rewrites the history file with only its last size entries.
+// Entries cleared from memory are kept. h.mu must be held.
+func (h *History) compact() error {
+	if err := h.file.Close(); err != nil {
+		return err
+	}
+	h.file = nil
+
+	entries, _, err := readHistoryFile(h.path, h.size)
+	if err != nil {
+		return err
+	}
+	return h.rewrite(entries)
+}
+
+// rewrite replaces the history file with entries and reopens it for
+// appending.
+func (h *History) rewrite(entries []HistoryEntry) error {
+	var buf []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	h.file = file
+	h.fileLines = len(entries)
+	return nil
+}
+
+// append adds an entry, overwriting the oldest if the history is full.
+func (h *History) append(entry HistoryEntry) {
+	if len(h.entries) < h.size {
+		h.entries = append(h.entries, entry)
+		return
+	}
+	h.entries[h.start] = entry
+	h.start = (h.start + 1) % h.size
+}
+
+// ordered returns a copy of the entries, oldest first.
+func (h *History) ordered() []HistoryEntry {
+	entries := make([]HistoryEntry, 0, len(h.entries))
+	entries = append(entries, h.entries[h.start:]...)
+	return append(entries, h.entries[:h.start]...)
+}
+
+// Add records an entry, assigning its ID and (if unset) its time.
+func (h *History) Add(entry HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry.ID = h.nextID
+	h.nextID++
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	h.append(entry)
+
+	if h.file != nil {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			_, err = h.file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			slog.Error("Error writing history file", "err", err)
+			return
+		}
+
+		h.fileLines++
+		if h.fileLines >= 2*h.size {
+			if err := h.compact(); err != nil {
+				slog.Error("Error compacting history file", "err", err)
+			}
+		}
+	}
+}
+
+// Entries returns the recorded entries, oldest first.
+func (h *History) Entries() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ordered()
+}
+
+// Clear removes all entries from memory. The history file is kept.
+func (h *History) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = nil
+	h.start = 0
+}
+
+// Close closes the history file, if any.
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+// recordAuthnRequest records an AuthnRequest received at /sso. validationErr
+// is recorded as its status if the request was rejected.
+func (s *Server) recordAuthnRequest(r *http.Request, req *saml.IdpAuthnRequest, validationErr error) {
+	entry := HistoryEntry{
+		Type:       HistoryAuthnRequest,
+		RequestID:  req.Request.ID,
+		RelayState: req.RelayState,
+		Binding:    requestBinding(r),
+		XML:        string(req.RequestBuffer),
+	}
+	if req.Request.Issuer != nil {
+		entry.SP = req.Request.Issuer.Value
+	}
+	if req.Request.ForceAuthn != nil {
+		entry.ForceAuthn = *req.Request.ForceAuthn
+	}
+	if req.Request.IsPassive != nil {
+		entry.IsPassive = *req.Request.IsPassive
+	}
+	if validationErr != nil {
+		entry.Status = "Invalid: " + validationErr.Error()
+	}
+	s.history.Add(entry)
+}
+
+// recordMalformedAuthnRequest records a request to /sso whose SAMLRequest
+// couldn't be decoded, with the raw parameter in place of the XML.
+func (s *Server) recordMalformedAuthnRequest(r *http.Request, parseErr error) {
+	values := r.URL.Query()
+	if r.Method == http.MethodPost {
+		values = r.PostForm
+	}
+	s.history.Add(HistoryEntry{
+		Type:       HistoryAuthnRequest,
+		RelayState: values.Get("RelayState"),
+		Binding:    requestBinding(r),
+		Status:     "Invalid: " + parseErr.Error(),
+		XML:        values.Get("SAMLRequest"),
+	})
+}
+
+// recordResponse records a Response issued to an SP.
+func (s *Server) recordResponse(req *saml.IdpAuthnRequest, form *saml.IdpAuthnRequestForm, user string) {
+	responseBuf, err := base64.StdEncoding.DecodeString(form.SAMLResponse)
+	if err != nil {
+		s.requestLogger(req, user).Error("Error recording response", "err", err)
+		return
+	}
+
+	entry := HistoryEntry{
+		Type:        HistoryResponse,
+		SP:          req.ServiceProviderMetadata.EntityID,
+		User:        user,
+		RequestID:   req.Request.ID,
+		RelayState:  form.RelayState,
+		Binding:     req.ACSEndpoint.Binding,
+		Destination: form.URL,
+		XML:         string(responseBuf),
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(responseBuf); err == nil {
+		entry.Status = responseStatus(doc)
+	}
+	s.history.Add(entry)
+}
+
+// responseStatus returns the (nested) status codes of a Response document.
+func responseStatus(doc *etree.Document) string {
+	statusEl := doc.FindElement("//StatusCode")
+	if statusEl == nil {
+		return ""
+	}
+	status := statusEl.SelectAttrValue("Value", "")
+	for inner := statusEl.FindElement("StatusCode"); inner != nil; inner = inner.FindElement("StatusCode") {
+		status += " / " + inner.SelectAttrValue("Value", "")
+	}
+	return status
+}
+
+// handleHistory shows the recorded requests and responses, newest first.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	entries := s.history.Entries()
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	for i := range entries {
+		entries[i].XML = prettyXML([]byte(entries[i].XML))
+	}
+
+	renderTemplate(w, "history.html", entries)
+}
+
+// handleHistoryAPI returns the recorded entries as JSON, oldest first,
+// optionally filtered by sp, type and since (an entry ID). DELETE clears
+// the history.
+func (s *Server) handleHistoryAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		s.history.Clear()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var since uint64
+	if v := query.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries := []HistoryEntry{}
+	for _, entry := range s.history.Entries() {
+		if sp := query.Get("sp"); sp != "" && entry.SP != sp {
+			continue
+		}
+		if typ := query.Get("type"); typ != "" && entry.Type != typ {
+			continue
+		}
+		if entry.ID <= since {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="saml-history.json"`)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		s.logger.Error("Error writing history", "err", err)
+	}
+}
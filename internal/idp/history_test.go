@@ -0,0 +1,226 @@
+package idp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryRingBuffer(t *testing.T) {
+	h, err := NewHistory(3, "")
+	if err != nil {
+		t.Fatalf("NewHistory failed: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		h.Add(HistoryEntry{Type: HistoryAuthnRequest})
+	}
+
+	entries := h.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].ID != 3 || entries[2].ID != 5 {
+		t.Errorf("Expected the newest entries 3-5, got %d-%d", entries[0].ID, entries[2].ID)
+	}
+	if entries[0].Time.IsZero() {
+		t.Error("Expected entry time to be set")
+	}
+
+	h.Clear()
+	if len(h.Entries()) != 0 {
+		t.Error("Expected history to be cleared")
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	h, err := NewHistory(10, path)
+	if err != nil {
+		t.Fatalf("NewHistory failed: %v", err)
+	}
+	h.Add(HistoryEntry{Type: HistoryAuthnRequest, SP: "https://sp.example.com"})
+	h.Add(HistoryEntry{Type: HistoryResponse, SP: "https://sp.example.com"})
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Entries are reloaded and IDs continue after a restart
+	h, err = NewHistory(10, path)
+	if err != nil {
+		t.Fatalf("NewHistory failed: %v", err)
+	}
+	defer h.Close()
+
+	h.Add(HistoryEntry{Type: HistoryAuthnRequest})
+	entries := h.Entries()
+	if len(entries) != 3 || entries[1].Type != HistoryResponse || entries[2].ID != 3 {
+		t.Errorf("Unexpected entries after reload: %+v", entries)
+	}
+}
+
+func TestHistoryFileSkipsUnreadableLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	content := `{"id":1,"type":"AuthnRequest","sp":"a","xml":""}
+{"id":2,"type":"Resp
+{"id":3,"type":"Response","sp":"a","xml":""}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write history file: %v", err)
+	}
+
+	h, err := NewHistory(10, path)
+	if err != nil {
+		t.Fatalf("Expected the unreadable line to be skipped, got %v", err)
+	}
+	defer h.Close()
+
+	entries := h.Entries()
+	if len(entries) != 2 || entries[0].ID != 1 || entries[1].ID != 3 {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), `"Resp`+"\n") {
+		t.Error("Expected the unreadable line to be removed from the file")
+	}
+}
+
+func TestHistoryFileCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	h, err := NewHistory(3, path)
+	if err != nil {
+		t.Fatalf("NewHistory failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		h.Add(HistoryEntry{Type: HistoryAuthnRequest})
+	}
+	h.Clear()
+	h.Add(HistoryEntry{Type: HistoryResponse})
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read history file: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines >= 6 {
+		t.Errorf("Expected the file to be compacted below twice the size, got %d lines", lines)
+	}
+
+	// The file keeps the entries cleared from memory
+	h, err = NewHistory(3, path)
+	if err != nil {
+		t.Fatalf("NewHistory failed: %v", err)
+	}
+	defer h.Close()
+	entries := h.Entries()
+	if len(entries) != 3 || entries[0].ID != 19 || entries[2].ID != 21 {
+		t.Errorf("Expected the newest entries 19-21 after reload, got %+v", entries)
+	}
+}
+
+func TestLoginFlowHistory(t *testing.T) {
+	server := testServer(t)
+
+	requestID := startSSOWithPolicy(t, server, "")
+	postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+
+	// An invalid NameIDPolicy is answered straight away
+	postAuthnRequest(server, `<samlp:NameIDPolicy Format="urn:example:nameid-format:custom"/>`)
+
+	req := httptest.NewRequest("GET", "/api/history?type=Response", nil)
+	w := httptest.NewRecorder()
+	server.handleHistoryAPI(w, req)
+
+	var responses []HistoryEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(responses))
+	}
+	first := responses[0]
+	if first.SP != "https://sp.example.com" || first.User != "Test User" || first.RequestID != "id-test-request" ||
+		first.RelayState != "test-relay-state" || first.Destination != "https://sp.example.com/acs" {
+		t.Errorf("Unexpected response entry: %+v", first)
+	}
+	if !strings.HasPrefix(first.Status, "urn:oasis:names:tc:SAML:2.0:status:Success") || !strings.Contains(first.XML, "Assertion") {
+		t.Errorf("Expected successful response with its XML, got %q", first.Status)
+	}
+	if !strings.Contains(responses[1].Status, "InvalidNameIDPolicy") {
+		t.Errorf("Expected InvalidNameIDPolicy status, got %q", responses[1].Status)
+	}
+
+	requests := 0
+	for _, entry := range server.history.Entries() {
+		if entry.Type == HistoryAuthnRequest {
+			requests++
+			if entry.SP != "https://sp.example.com" || entry.Binding == "" || !strings.Contains(entry.XML, "AuthnRequest") {
+				t.Errorf("Unexpected request entry: %+v", entry)
+			}
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 AuthnRequests, got %d", requests)
+	}
+
+	// The UI lists every entry
+	w = httptest.NewRecorder()
+	server.handleHistory(w, httptest.NewRequest("GET", "/history", nil))
+	if !strings.Contains(w.Body.String(), "#4 Response") {
+		t.Error("Expected history page to list the entries")
+	}
+
+	w = httptest.NewRecorder()
+	server.handleHistoryAPI(w, httptest.NewRequest("DELETE", "/api/history", nil))
+	if w.Code != http.StatusNoContent || len(server.history.Entries()) != 0 {
+		t.Error("Expected DELETE to clear the history")
+	}
+}
+
+func TestHistoryMalformedAuthnRequest(t *testing.T) {
+	server := testServer(t)
+
+	form := url.Values{"SAMLRequest": {"not base64!"}, "RelayState": {"test-relay-state"}}
+	w := postForm(server, server.handleSSO, "/sso", form)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", w.Code)
+	}
+
+	entries := server.history.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("Expected the malformed request to be recorded, got %d entries", len(entries))
+	}
+	entry := entries[0]
+	if entry.Type != HistoryAuthnRequest || entry.XML != "not base64!" || entry.RelayState != "test-relay-state" ||
+		!strings.HasPrefix(entry.Status, "Invalid: ") {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+}
+
+func TestHistoryForceAuthn(t *testing.T) {
+	server := testServer(t)
+
+	authnRequest := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" `+
+		`xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-force-authn" Version="2.0" ForceAuthn="true" `+
+		`IssueInstant="%s" AssertionConsumerServiceURL="https://sp.example.com/acs">`+
+		`<saml:Issuer>https://sp.example.com</saml:Issuer></samlp:AuthnRequest>`,
+		time.Now().UTC().Format(time.RFC3339))
+	form := url.Values{"SAMLRequest": {base64.StdEncoding.EncodeToString([]byte(authnRequest))}}
+	postForm(server, server.handleSSO, "/sso", form)
+
+	entries := server.history.Entries()
+	if len(entries) != 1 || !entries[0].ForceAuthn || entries[0].RequestID != "id-force-authn" {
+		t.Errorf("Expected the request to be recorded with ForceAuthn, got %+v", entries)
+	}
+}
@@ -1,10 +1,14 @@
 package idp
 
 import (
+	"context"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/breakroom/saml-test-idp/internal/config"
 	"github.com/crewjam/saml"
@@ -18,6 +22,27 @@ type Server struct {
 	idp             *saml.IdentityProvider
 	spProvider      *ServiceProviderProvider
 	sessionProvider *SessionProvider
+	logger          *slog.Logger
+	history         *History
+	metrics         *Metrics
+	ids             IDSource
+	clock           Clock
+	webhooks        *Webhooks
+
+	// deterministic leaves the client address out of assertions
+	deterministic bool
+
+	// basePath is the path of the base URL, without a trailing slash,
+	// that every route is served under
+	basePath string
+	// tenant names the server when several IdPs share the process, and
+	// routeHost, if set, restricts its routes to that host name
+	tenant    string
+	routeHost string
+
+	// persistentIDSalt keys generated persistent NameIDs
+	persistentIDSalt []byte
+	persistentIDs    persistentIDRegistry
 }
 
 // New creates a new IDP server from configuration.
@@ -47,14 +72,39 @@ func New(cfg *config.Config) (*Server, error) {
 	}
 
 	server := &Server{
-		config:      cfg,
-		certificate: cert,
-		privateKey:  key,
-		spProvider:  spProvider,
+		config:           cfg,
+		logger:           slog.Default(),
+		metrics:          NewMetrics(),
+		ids:              CryptoIDSource(),
+		clock:            SystemClock(),
+		certificate:      cert,
+		privateKey:       key,
+		spProvider:       spProvider,
+		persistentIDSalt: []byte(cfg.IDP.PersistentIDSalt),
+		basePath:         strings.TrimSuffix(baseURL.Path, "/"),
 	}
 
+	// Without a configured salt, derive one from the signing key so
+	// persistent NameIDs stay stable across restarts
+	if len(server.persistentIDSalt) == 0 {
+		sum := sha256.Sum256(x509.MarshalPKCS1PrivateKey(key))
+		server.persistentIDSalt = sum[:]
+	}
+
+	if cfg.Deterministic.Enabled {
+		server.SetDeterministic(cfg.Deterministic.StartTime(), cfg.Deterministic.Seed)
+	}
+
+	history, err := NewHistory(cfg.History.Size, cfg.History.GetFilePath())
+	if err != nil {
+		return nil, err
+	}
+	server.history = history
+	server.webhooks = NewWebhooks(cfg.Webhooks)
+
 	// Create session provider (manages pending requests only, no persistent sessions)
-	server.sessionProvider = NewSessionProvider()
+	server.sessionProvider = NewSessionProvider(cfg.PendingRequests.TTL, cfg.PendingRequests.Max)
+	server.sessionProvider.StartSweeper(cfg.PendingRequests.SweepInterval)
 
 	// Create SAML IDP
 	server.idp = &saml.IdentityProvider{
@@ -63,12 +113,12 @@ func New(cfg *config.Config) (*Server, error) {
 		MetadataURL: url.URL{
 			Scheme: baseURL.Scheme,
 			Host:   baseURL.Host,
-			Path:   "/metadata",
+			Path:   server.path("/metadata"),
 		},
 		SSOURL: url.URL{
 			Scheme: baseURL.Scheme,
 			Host:   baseURL.Host,
-			Path:   "/sso",
+			Path:   server.path("/sso"),
 		},
 		ServiceProviderProvider: spProvider,
 		SessionProvider:         server.sessionProvider,
@@ -77,11 +127,32 @@ func New(cfg *config.Config) (*Server, error) {
 	return server, nil
 }
 
-// RegisterRoutes registers HTTP routes for the IDP.
+// RegisterRoutes registers HTTP routes for the IDP, under the path of the
+// base URL (and its host name, for tenants served on different hosts).
 func (s *Server) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/metadata", s.handleMetadata)
-	mux.HandleFunc("/sso", s.handleSSO)
-	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc(s.pattern("/metadata"), s.handleMetadata)
+	mux.HandleFunc(s.pattern("/sso"), s.handleSSO)
+	mux.HandleFunc(s.pattern("/login"), s.handleLogin)
+	mux.HandleFunc(s.pattern("/mfa"), s.handleMFA)
+	mux.HandleFunc(s.pattern("/consent"), s.handleConsent)
+	mux.HandleFunc(s.pattern("/history"), s.handleHistory)
+	mux.HandleFunc(s.pattern("/api/history"), s.handleHistoryAPI)
+	mux.HandleFunc(s.pattern("/healthz"), s.handleHealthz)
+	mux.HandleFunc(s.pattern("/readyz"), s.handleReadyz)
+
+	// Metrics served on their own listener are registered by the caller
+	if s.config.Metrics.Enabled && s.config.Metrics.Address == "" {
+		mux.HandleFunc(s.pattern("/metrics"), s.handleMetrics)
+	}
+}
+
+// entityID returns the IdP's entity ID: idp.entity_id if configured, else
+// the URL of its metadata as served to the request.
+func (s *Server) entityID(idp *saml.IdentityProvider) string {
+	if s.config.IDP.EntityID != "" {
+		return s.config.IDP.EntityID
+	}
+	return idp.MetadataURL.String()
 }
 
 // GetIDP returns the underlying SAML IDP.
@@ -99,6 +170,33 @@ func (s *Server) GetSessionProvider() *SessionProvider {
 	return s.sessionProvider
 }
 
+// GetHistory returns the request/response history.
+func (s *Server) GetHistory() *History {
+	return s.history
+}
+
+// Shutdown stops the pending request sweeper and releases resources held
+// by the server, such as the history file, after waiting for pending
+// webhook deliveries until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.sessionProvider.Stop()
+	s.webhooks.Shutdown(ctx)
+	return s.history.Close()
+}
+
+// Close is Shutdown without waiting: pending webhook deliveries are dropped.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return s.Shutdown(ctx)
+}
+
+// SetIDSource replaces the source of random identifiers, e.g. with a
+// seeded one so tests get predictable IDs.
+func (s *Server) SetIDSource(ids IDSource) {
+	s.ids = ids
+}
+
 // GetConfig returns the server configuration.
 func (s *Server) GetConfig() *config.Config {
 	return s.config
@@ -0,0 +1,48 @@
+package idp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// IDSource generates the random identifiers used for pending requests,
+// session indexes, transient NameIDs and response IDs. Implementations must
+// be safe for concurrent use.
+type IDSource interface {
+	// HexID returns n random lowercase hex characters.
+	HexID(n int) string
+}
+
+// readerIDSource reads identifiers from an io.Reader.
+type readerIDSource struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+// NewIDSource returns an IDSource reading from r, which is read by one
+// caller at a time. Pass a seeded generator for deterministic IDs in tests.
+func NewIDSource(r io.Reader) IDSource {
+	return &readerIDSource{r: r}
+}
+
+// CryptoIDSource returns an IDSource reading from crypto/rand. It's the
+// server's default.
+func CryptoIDSource() IDSource {
+	return NewIDSource(rand.Reader)
+}
+
+func (s *readerIDSource) HexID(n int) string {
+	buf := make([]byte, (n+1)/2)
+
+	s.mu.Lock()
+	_, err := io.ReadFull(s.r, buf)
+	s.mu.Unlock()
+	if err != nil {
+		slog.Error("Error reading ID source, falling back to crypto/rand", "err", err)
+		rand.Read(buf)
+	}
+	return hex.EncodeToString(buf)[:n]
+}
@@ -0,0 +1,103 @@
+package idp
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestCryptoIDSource(t *testing.T) {
+	ids := CryptoIDSource()
+	id := ids.HexID(16)
+	if len(id) != 16 {
+		t.Errorf("Expected length 16, got %d", len(id))
+	}
+
+	// Verify all characters are hex
+	for _, c := range id {
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
+			t.Errorf("Invalid hex character: %c", c)
+		}
+	}
+
+	if id == ids.HexID(16) {
+		t.Error("HexID should generate unique values")
+	}
+	if odd := ids.HexID(5); len(odd) != 5 {
+		t.Errorf("Expected length 5, got %d", len(odd))
+	}
+}
+
+func TestIDSourceConcurrent(t *testing.T) {
+	ids := CryptoIDSource()
+	seen := make(map[string]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := ids.HexID(32)
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[id] {
+				t.Errorf("Duplicate ID %s", id)
+			}
+			seen[id] = true
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIDSourceFromReader(t *testing.T) {
+	ids := NewIDSource(bytes.NewReader([]byte{0x01, 0x23, 0xab}))
+	if id := ids.HexID(6); id != "0123ab" {
+		t.Errorf("Expected 0123ab, got %s", id)
+	}
+
+	// An exhausted reader falls back to crypto/rand
+	if id := ids.HexID(6); len(id) != 6 {
+		t.Errorf("Expected a fallback ID, got %q", id)
+	}
+}
+
+// failingReader always returns an error.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("broken") }
+
+func TestIDSourceReaderError(t *testing.T) {
+	if id := NewIDSource(failingReader{}).HexID(8); len(id) != 8 {
+		t.Errorf("Expected a fallback ID, got %q", id)
+	}
+}
+
+func TestServerSeededIDs(t *testing.T) {
+	pendingIDs := func() string {
+		server := testServer(t)
+		server.SetIDSource(NewIDSource(rand.NewChaCha8([32]byte{1})))
+		return startSSO(t, server)
+	}
+
+	first := pendingIDs()
+	if first != pendingIDs() {
+		t.Error("Expected seeded ID sources to produce the same pending request ID")
+	}
+	if len(first) != 32 {
+		t.Errorf("Expected a 32 character pending request ID, got %q", first)
+	}
+
+	// The pending request is stored under the generated ID
+	server := testServer(t)
+	server.SetIDSource(NewIDSource(rand.NewChaCha8([32]byte{1})))
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected login with the seeded request ID to succeed, got %d", w.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package idp
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// Transaction outcomes logged besides the SAML status codes.
+const (
+	outcomeSuccess        = "success"
+	outcomeInvalidRequest = "invalid_request"
+)
+
+// transactionAttrs returns the log fields identifying a SAML transaction.
+// req may be nil if the AuthnRequest couldn't be parsed.
+func transactionAttrs(req *saml.IdpAuthnRequest, user string) []any {
+	var attrs []any
+	if req == nil {
+		return attrs
+	}
+	attrs = append(attrs, "request_id", req.Request.ID)
+	if sp := requestSP(req); sp != "" {
+		attrs = append(attrs, "sp", sp)
+	}
+	if user != "" {
+		attrs = append(attrs, "user", user)
+	}
+	return attrs
+}
+
+// requestSP returns the entity ID of the SP that sent an AuthnRequest,
+// falling back to its Issuer if the SP is unknown.
+func requestSP(req *saml.IdpAuthnRequest) string {
+	if req == nil {
+		return ""
+	}
+	if req.ServiceProviderMetadata != nil {
+		return req.ServiceProviderMetadata.EntityID
+	}
+	if req.Request.Issuer != nil {
+		return req.Request.Issuer.Value
+	}
+	return ""
+}
+
+// requestLogger returns the server's logger with the fields identifying a
+// SAML transaction.
+func (s *Server) requestLogger(req *saml.IdpAuthnRequest, user string) *slog.Logger {
+	return s.logger.With(transactionAttrs(req, user)...)
+}
+
+// logTransaction logs and counts the end of a SAML transaction: a response
+// sent to the SP, or an AuthnRequest rejected at /sso. binding is that of
+// the last message and start is when the AuthnRequest was received.
+func (s *Server) logTransaction(req *saml.IdpAuthnRequest, user, binding, outcome, message string, start time.Time) {
+	s.metrics.observeTransaction(requestSP(req), user, outcome)
+
+	attrs := append(transactionAttrs(req, user),
+		"binding", binding,
+		"outcome", outcome,
+		"duration", time.Since(start),
+	)
+	if message != "" {
+		attrs = append(attrs, "message", message)
+	}
+
+	level := slog.LevelInfo
+	if outcome != outcomeSuccess {
+		level = slog.LevelWarn
+	}
+	s.logger.Log(context.Background(), level, "SAML transaction", attrs...)
+}
+
+// requestBinding returns the binding an AuthnRequest was received with.
+func requestBinding(r *http.Request) string {
+	if r.Method == http.MethodGet {
+		return saml.HTTPRedirectBinding
+	}
+	return saml.HTTPPostBinding
+}
@@ -0,0 +1,84 @@
+package idp
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/crewjam/saml"
+)
+
+// captureLogs makes the server log JSON records to the returned buffer.
+func captureLogs(server *Server) *bytes.Buffer {
+	var buf bytes.Buffer
+	server.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	return &buf
+}
+
+// transactionRecords returns the "SAML transaction" log records.
+func transactionRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		if record["msg"] == "SAML transaction" {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestLogTransactionLogin(t *testing.T) {
+	server := testServer(t)
+	buf := captureLogs(server)
+
+	requestID := startSSO(t, server)
+	postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+
+	records := transactionRecords(t, buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 transaction record, got %d", len(records))
+	}
+	record := records[0]
+	want := map[string]interface{}{
+		"level":      "INFO",
+		"request_id": "id-test-request",
+		"sp":         "https://sp.example.com",
+		"user":       "Test User",
+		"binding":    saml.HTTPPostBinding,
+		"outcome":    outcomeSuccess,
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("Expected %s %v, got %v", key, value, record[key])
+		}
+	}
+	if _, ok := record["duration"]; !ok {
+		t.Error("Expected a duration")
+	}
+}
+
+func TestLogTransactionSSOError(t *testing.T) {
+	server := testServer(t)
+	buf := captureLogs(server)
+
+	postAuthnRequest(server, `<samlp:NameIDPolicy Format="urn:example:unsupported"/>`)
+
+	records := transactionRecords(t, buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 transaction record, got %d", len(records))
+	}
+	if records[0]["level"] != "WARN" || records[0]["outcome"] != saml.StatusInvalidNameIDPolicy {
+		t.Errorf("Expected a warning with InvalidNameIDPolicy outcome, got %v", records[0])
+	}
+	if records[0]["message"] == nil {
+		t.Error("Expected the status message to be logged")
+	}
+}
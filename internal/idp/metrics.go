@@ -0,0 +1,140 @@
+package idp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signingDurationBuckets are the upper bounds, in seconds, of the response
+// signing latency histogram.
+var signingDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Metrics collects counters and histograms exposed in the Prometheus text
+// format at /metrics.
+type Metrics struct {
+	mu          sync.Mutex
+	ssoRequests map[labelPair]uint64
+	logins      map[labelPair]uint64
+	signing     histogram
+}
+
+// labelPair holds the values of a metric's two labels.
+type labelPair [2]string
+
+// histogram is a cumulative Prometheus histogram.
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// NewMetrics creates an empty metrics collector.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		ssoRequests: make(map[labelPair]uint64),
+		logins:      make(map[labelPair]uint64),
+		signing:     histogram{buckets: make([]uint64, len(signingDurationBuckets))},
+	}
+}
+
+// observeTransaction counts a finished SSO transaction, and the login if it
+// succeeded.
+func (m *Metrics) observeTransaction(sp, user, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ssoRequests[labelPair{sp, outcome}]++
+	if outcome == outcomeSuccess {
+		m.logins[labelPair{sp, user}]++
+	}
+}
+
+// observeSigning records how long signing a response or assertion took.
+func (m *Metrics) observeSigning(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seconds := d.Seconds()
+	for i, bound := range signingDurationBuckets {
+		if seconds <= bound {
+			m.signing.buckets[i]++
+		}
+	}
+	m.signing.sum += seconds
+	m.signing.count++
+}
+
+// handleMetrics serves the metrics in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.writeMetrics(w)
+}
+
+// MetricsHandler returns the /metrics handler, for serving it on a separate
+// listener.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(s.handleMetrics)
+}
+
+// writeMetrics writes every metric, including gauges read at scrape time.
+func (s *Server) writeMetrics(w io.Writer) {
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "saml_idp_sso_requests_total", "counter", "SSO transactions by SP and outcome.")
+	writeCounters(w, "saml_idp_sso_requests_total", [2]string{"sp", "outcome"}, m.ssoRequests)
+
+	writeHeader(w, "saml_idp_logins_total", "counter", "Successful logins by SP and user.")
+	writeCounters(w, "saml_idp_logins_total", [2]string{"sp", "user"}, m.logins)
+
+	const signing = "saml_idp_response_signing_duration_seconds"
+	writeHeader(w, signing, "histogram", "Time taken to sign responses and assertions.")
+	for i, bound := range signingDurationBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", signing, strconv.FormatFloat(bound, 'g', -1, 64), m.signing.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", signing, m.signing.count)
+	fmt.Fprintf(w, "%s_sum %s\n", signing, strconv.FormatFloat(m.signing.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", signing, m.signing.count)
+
+	writeHeader(w, "saml_idp_pending_requests", "gauge", "AuthnRequests waiting for the user to log in.")
+	fmt.Fprintf(w, "saml_idp_pending_requests %d\n", s.sessionProvider.PendingCount())
+
+	writeHeader(w, "saml_idp_certificate_expiry_timestamp_seconds", "gauge", "Expiry time of the signing certificate.")
+	fmt.Fprintf(w, "saml_idp_certificate_expiry_timestamp_seconds %d\n", s.certificate.NotAfter.Unix())
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeCounters writes a two-label counter, sorted by label values.
+func writeCounters(w io.Writer, name string, labels [2]string, values map[labelPair]uint64) {
+	keys := make([]labelPair, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\",%s=\"%s\"} %d\n", name,
+			labels[0], escapeLabelValue(key[0]), labels[1], escapeLabelValue(key[1]), values[key])
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
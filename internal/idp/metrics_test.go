@@ -0,0 +1,75 @@
+package idp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := testServer(t)
+	server.config.Metrics.Enabled = true
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+
+	requestID := startSSO(t, server)
+	postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	postAuthnRequest(server, `<samlp:NameIDPolicy Format="urn:example:unsupported"/>`)
+	startSSO(t, server)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`saml_idp_sso_requests_total{sp="https://sp.example.com",outcome="success"} 1`,
+		`saml_idp_sso_requests_total{sp="https://sp.example.com",outcome="urn:oasis:names:tc:SAML:2.0:status:InvalidNameIDPolicy"} 1`,
+		`saml_idp_logins_total{sp="https://sp.example.com",user="Test User"} 1`,
+		`saml_idp_response_signing_duration_seconds_count 3`,
+		`saml_idp_pending_requests 1`,
+		`saml_idp_certificate_expiry_timestamp_seconds `,
+		`# TYPE saml_idp_response_signing_duration_seconds histogram`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	server := testServer(t)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected /metrics to be disabled by default, got %d", w.Code)
+	}
+}
+
+func TestMetricsSigningHistogram(t *testing.T) {
+	m := NewMetrics()
+	m.observeSigning(3 * time.Millisecond)
+	m.observeSigning(2 * time.Second)
+
+	if m.signing.count != 2 {
+		t.Errorf("Expected 2 observations, got %d", m.signing.count)
+	}
+	// 3ms falls in the 5ms bucket and above; 2s only in +Inf
+	if m.signing.buckets[1] != 0 || m.signing.buckets[2] != 1 || m.signing.buckets[len(signingDurationBuckets)-1] != 1 {
+		t.Errorf("Unexpected buckets: %v", m.signing.buckets)
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	if got := escapeLabelValue("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("Unexpected escaped value %q", got)
+	}
+}
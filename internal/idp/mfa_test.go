@@ -0,0 +1,182 @@
+package idp
+
+import (
+	"encoding/base32"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+)
+
+// RFC 6238 Appendix B test secret (SHA1): ASCII "12345678901234567890"
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	key, err := decodeTOTPSecret(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("decodeTOTPSecret failed: %v", err)
+	}
+
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+	}
+
+	for _, tt := range tests {
+		code := totpCode(key, time.Unix(tt.unix, 0), 8)
+		if code != tt.expected {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, code, tt.expected)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	// Lowercase secrets with spaces are accepted, as shown by many apps
+	secret := strings.ToLower(rfc6238Secret[:8] + " " + rfc6238Secret[8:])
+
+	tests := []struct {
+		name  string
+		code  string
+		valid bool
+	}{
+		{"current period", "081804", true},
+		{"previous period", totpCodeAt(t, now.Add(-30*time.Second)), true},
+		{"two periods ago", totpCodeAt(t, now.Add(-60*time.Second)), false},
+		{"wrong code", "000000", false},
+		{"wrong length", "81804", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := verifyTOTP(secret, tt.code, now)
+			if err != nil {
+				t.Fatalf("verifyTOTP failed: %v", err)
+			}
+			if valid != tt.valid {
+				t.Errorf("verifyTOTP(%q) = %v, want %v", tt.code, valid, tt.valid)
+			}
+		})
+	}
+
+	if _, err := verifyTOTP("not base32!", "123456", now); err == nil {
+		t.Error("Expected error for invalid secret")
+	}
+}
+
+func totpCodeAt(t *testing.T, at time.Time) string {
+	t.Helper()
+	key, err := decodeTOTPSecret(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("decodeTOTPSecret failed: %v", err)
+	}
+	return totpCode(key, at, totpDigits)
+}
+
+func TestMFAPushFlow(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.MFA = config.MFAPush
+
+	// Selecting a user shows the challenge instead of posting to the SP
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	if strings.Contains(w.Body.String(), "SAMLResponse") {
+		t.Fatal("Expected MFA challenge, got SAML response")
+	}
+	if !strings.Contains(w.Body.String(), `value="approve"`) {
+		t.Error("Expected push approval form")
+	}
+
+	// Approving completes the login with an MFA authn context
+	w = postForm(server, server.handleMFA, "/mfa?request_id="+requestID, url.Values{"user": {"Test User"}, "action": {"approve"}})
+	response := decodeSAMLResponse(t, w.Body.String())
+	if !strings.Contains(response, MobileTwoFactorAuthnContext) {
+		t.Error("Expected MobileTwoFactorContract authn context")
+	}
+	if !strings.Contains(response, `Name="amr"`) || !strings.Contains(response, ">mca<") {
+		t.Error("Expected amr attribute with mca value")
+	}
+}
+
+func TestMFAPushDeny(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.MFA = config.MFAPush
+
+	requestID := startSSO(t, server)
+	postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	w := postForm(server, server.handleMFA, "/mfa?request_id="+requestID, url.Values{"user": {"Test User"}, "action": {"deny"}})
+
+	response := decodeSAMLResponse(t, w.Body.String())
+	if !strings.Contains(response, "urn:oasis:names:tc:SAML:2.0:status:AuthnFailed") {
+		t.Error("Expected AuthnFailed status")
+	}
+	if strings.Contains(response, "Assertion") {
+		t.Error("Expected no assertion in failure response")
+	}
+	if _, ok := server.sessionProvider.GetPendingRequest(requestID); ok {
+		t.Error("Expected pending request to be deleted after denial")
+	}
+}
+
+func TestMFATOTPFlow(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.Users[0].MFA = config.MFATOTP
+	sp.Users[0].TOTPSecret = rfc6238Secret
+
+	requestID := startSSO(t, server)
+	postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+
+	// A wrong code re-renders the challenge with an error
+	w := postForm(server, server.handleMFA, "/mfa?request_id="+requestID, url.Values{"user": {"Test User"}, "code": {"000000"}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Invalid verification code") {
+		t.Fatalf("Expected invalid code message, got %d: %s", w.Code, w.Body.String())
+	}
+
+	code := totpCodeAt(t, time.Now())
+	w = postForm(server, server.handleMFA, "/mfa?request_id="+requestID, url.Values{"user": {"Test User"}, "code": {code}})
+	response := decodeSAMLResponse(t, w.Body.String())
+	if !strings.Contains(response, TimeSyncTokenAuthnContext) {
+		t.Error("Expected TimeSyncToken authn context")
+	}
+	if !strings.Contains(response, ">otp<") {
+		t.Error("Expected amr attribute with otp value")
+	}
+}
+
+func TestMFAUserMismatch(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.Users[0].MFA = config.MFAPush
+	sp.Users = append(sp.Users, config.User{Name: "Other User", NameID: "other@example.com", MFA: config.MFAPush})
+	requestID := startSSO(t, server)
+
+	// The second factor can't be passed without the login step
+	w := postForm(server, server.handleMFA, "/mfa?request_id="+requestID, url.Values{"user": {"Other User"}, "action": {"approve"}})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 without the login step, got %d", w.Code)
+	}
+
+	// Nor for a different user than the one who logged in
+	postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	w = postForm(server, server.handleMFA, "/mfa?request_id="+requestID, url.Values{"user": {"Other User"}, "action": {"approve"}})
+	if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "SAMLResponse") {
+		t.Fatalf("Expected status 400 for a different user, got %d", w.Code)
+	}
+
+	w = postForm(server, server.handleMFA, "/mfa?request_id="+requestID, url.Values{"user": {"Test User"}, "action": {"approve"}})
+	if response := decodeSAMLResponse(t, w.Body.String()); !strings.Contains(response, ">test@example.com<") {
+		t.Errorf("Expected the logged in user's assertion, got %s", response)
+	}
+}
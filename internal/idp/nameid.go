@@ -1,6 +1,26 @@
 package idp
 
-import "github.com/crewjam/saml"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"sync"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// NameID format URIs not defined by the saml package.
+const (
+	X509SubjectNameNameIDFormat            saml.NameIDFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:X509SubjectName"
+	WindowsDomainQualifiedNameNameIDFormat saml.NameIDFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:WindowsDomainQualifiedName"
+	KerberosNameIDFormat                   saml.NameIDFormat = "urn:oasis:names:tc:SAML:2.0:nameid-format:kerberos"
+	EntityNameIDFormat                     saml.NameIDFormat = "urn:oasis:names:tc:SAML:2.0:nameid-format:entity"
+)
 
 // NameIDFormats maps friendly config names to SAML NameID format URIs.
 var NameIDFormats = map[string]saml.NameIDFormat{
@@ -8,6 +28,20 @@ var NameIDFormats = map[string]saml.NameIDFormat{
 	"persistent":  saml.PersistentNameIDFormat,
 	"transient":   saml.TransientNameIDFormat,
 	"unspecified": saml.UnspecifiedNameIDFormat,
+	"x509":        X509SubjectNameNameIDFormat,
+	"windows":     WindowsDomainQualifiedNameNameIDFormat,
+	"kerberos":    KerberosNameIDFormat,
+	"entity":      EntityNameIDFormat,
+}
+
+// supportedNameIDFormats returns all NameID format URIs, sorted, for metadata.
+func supportedNameIDFormats() []saml.NameIDFormat {
+	formats := make([]saml.NameIDFormat, 0, len(NameIDFormats))
+	for _, f := range NameIDFormats {
+		formats = append(formats, f)
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i] < formats[j] })
+	return formats
 }
 
 // GetNameIDFormat returns the SAML NameID format for a given config value.
@@ -28,3 +62,131 @@ func GetNameIDFormatString(format saml.NameIDFormat) string {
 	}
 	return "email"
 }
+
+// errInvalidNameIDPolicy is returned when a NameIDPolicy cannot be satisfied.
+var errInvalidNameIDPolicy = errors.New("invalid NameIDPolicy")
+
+// resolveNameIDFormat picks the NameID format for a login. A format requested
+// in the AuthnRequest's NameIDPolicy is used if the user can supply it;
+// otherwise (or when none or unspecified is requested) the configured
+// format applies.
+func resolveNameIDFormat(policy *saml.NameIDPolicy, sp *config.ServiceProvider, user *config.User) (saml.NameIDFormat, error) {
+	configured := GetNameIDFormat(sp.NameIDFormatFor(user))
+	if policy == nil || policy.Format == nil || *policy.Format == "" {
+		return configured, nil
+	}
+
+	requested := saml.NameIDFormat(*policy.Format)
+	if requested == saml.UnspecifiedNameIDFormat {
+		return configured, nil
+	}
+	if !isSupportedNameIDFormat(requested) {
+		return "", fmt.Errorf("%w: unsupported format %s", errInvalidNameIDPolicy, requested)
+	}
+	if !userSuppliesNameIDFormat(user, configured, requested) {
+		return "", fmt.Errorf("%w: user %s has no %s NameID", errInvalidNameIDPolicy, user.Name, requested)
+	}
+	return requested, nil
+}
+
+// isSupportedNameIDFormat reports whether a format URI is in NameIDFormats.
+func isSupportedNameIDFormat(format saml.NameIDFormat) bool {
+	for _, f := range NameIDFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// userSuppliesNameIDFormat reports whether a NameID in the given format can
+// be sent for the user: generated formats always can, as can the user's
+// configured format, formats listed in name_ids, and email when the
+// name_id is an email address.
+func userSuppliesNameIDFormat(user *config.User, configured, format saml.NameIDFormat) bool {
+	switch {
+	case format == configured:
+		return true
+	case user.NameIDs[GetNameIDFormatString(format)] != "":
+		return true
+	case format == saml.TransientNameIDFormat || format == saml.PersistentNameIDFormat:
+		return !user.StaticNameID
+	case format == saml.EmailAddressNameIDFormat:
+		_, err := mail.ParseAddress(user.NameID)
+		return err == nil
+	}
+	return false
+}
+
+// spNameQualifier returns the SPNameQualifier for a login: the one requested
+// in the NameIDPolicy (e.g. an affiliation) or the SP's entity ID.
+func spNameQualifier(policy *saml.NameIDPolicy, spEntityID string) string {
+	if policy != nil && policy.SPNameQualifier != nil && *policy.SPNameQualifier != "" {
+		return *policy.SPNameQualifier
+	}
+	return spEntityID
+}
+
+// nameIDValue returns the NameID value to send for a user. Values listed in
+// the user's name_ids are sent as-is; otherwise transient NameIDs are random
+// for every login and persistent NameIDs are a stable pairwise identifier
+// for the user and SP name qualifier. Other formats (and users with
+// static_name_id set) send the configured name_id unchanged.
+func (s *Server) nameIDValue(user *config.User, spNameQualifier string, format saml.NameIDFormat) string {
+	if v := user.NameIDs[GetNameIDFormatString(format)]; v != "" {
+		return v
+	}
+	if !generatesNameID(user, format) {
+		return user.NameID
+	}
+
+	switch format {
+	case saml.TransientNameIDFormat:
+		return "_" + s.ids.HexID(40)
+	case saml.PersistentNameIDFormat:
+		return persistentID(s.persistentIDSalt, user.NameID, spNameQualifier)
+	}
+	return user.NameID
+}
+
+// generatesNameID reports whether the NameID for a user in the given format
+// is generated by the IdP rather than taken from the configuration.
+func generatesNameID(user *config.User, format saml.NameIDFormat) bool {
+	if user.StaticNameID || user.NameIDs[GetNameIDFormatString(format)] != "" {
+		return false
+	}
+	return format == saml.TransientNameIDFormat || format == saml.PersistentNameIDFormat
+}
+
+// persistentIDRegistry records which persistent NameIDs have been issued,
+// so requests with AllowCreate="false" can be refused for new identifiers.
+type persistentIDRegistry struct {
+	mu     sync.Mutex
+	issued map[string]bool
+}
+
+// issue records a persistent NameID, returning false without recording it
+// if it is new and allowCreate is false.
+func (r *persistentIDRegistry) issue(nameID string, allowCreate bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.issued == nil {
+		r.issued = make(map[string]bool)
+	}
+	if !r.issued[nameID] && !allowCreate {
+		return false
+	}
+	r.issued[nameID] = true
+	return true
+}
+
+// persistentID derives an opaque pairwise identifier from the user's
+// name_id and the SP entity ID, keyed with the IdP's salt.
+func persistentID(salt []byte, nameID, spEntityID string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(nameID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(spEntityID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
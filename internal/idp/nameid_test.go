@@ -1,8 +1,13 @@
 package idp
 
 import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/breakroom/saml-test-idp/internal/config"
 	"github.com/crewjam/saml"
 )
 
@@ -15,6 +20,8 @@ func TestGetNameIDFormat(t *testing.T) {
 		{"persistent", saml.PersistentNameIDFormat},
 		{"transient", saml.TransientNameIDFormat},
 		{"unspecified", saml.UnspecifiedNameIDFormat},
+		{"x509", X509SubjectNameNameIDFormat},
+		{"kerberos", KerberosNameIDFormat},
 		{"unknown", saml.EmailAddressNameIDFormat}, // Default
 		{"", saml.EmailAddressNameIDFormat},        // Empty defaults to email
 	}
@@ -59,3 +66,187 @@ func TestNameIDFormatsCompleteness(t *testing.T) {
 		}
 	}
 }
+
+func TestNameIDValue(t *testing.T) {
+	server := &Server{persistentIDSalt: []byte("salt"), ids: CryptoIDSource()}
+	user := &config.User{NameID: "alice@example.com"}
+
+	if got := server.nameIDValue(user, "https://sp1.example.com", saml.EmailAddressNameIDFormat); got != "alice@example.com" {
+		t.Errorf("Expected static NameID for email format, got %q", got)
+	}
+	if got := server.nameIDValue(user, "https://sp1.example.com", saml.UnspecifiedNameIDFormat); got != "alice@example.com" {
+		t.Errorf("Expected static NameID for unspecified format, got %q", got)
+	}
+
+	// Transient NameIDs are fresh for every login
+	t1 := server.nameIDValue(user, "https://sp1.example.com", saml.TransientNameIDFormat)
+	t2 := server.nameIDValue(user, "https://sp1.example.com", saml.TransientNameIDFormat)
+	if t1 == t2 || t1 == user.NameID {
+		t.Errorf("Expected distinct opaque transient NameIDs, got %q and %q", t1, t2)
+	}
+
+	// Persistent NameIDs are stable per SP but differ between SPs
+	p1 := server.nameIDValue(user, "https://sp1.example.com", saml.PersistentNameIDFormat)
+	if p1 != server.nameIDValue(user, "https://sp1.example.com", saml.PersistentNameIDFormat) {
+		t.Error("Expected stable persistent NameID")
+	}
+	if p1 == server.nameIDValue(user, "https://sp2.example.com", saml.PersistentNameIDFormat) {
+		t.Error("Expected pairwise persistent NameIDs to differ between SPs")
+	}
+	if p1 == user.NameID || strings.Contains(p1, "alice") {
+		t.Errorf("Expected opaque persistent NameID, got %q", p1)
+	}
+
+	other := &Server{persistentIDSalt: []byte("other")}
+	if p1 == other.nameIDValue(user, "https://sp1.example.com", saml.PersistentNameIDFormat) {
+		t.Error("Expected persistent NameID to depend on the salt")
+	}
+
+	user.StaticNameID = true
+	if got := server.nameIDValue(user, "https://sp1.example.com", saml.PersistentNameIDFormat); got != "alice@example.com" {
+		t.Errorf("Expected static_name_id to send name_id unchanged, got %q", got)
+	}
+}
+
+func TestLoginFlowPersistentNameID(t *testing.T) {
+	server := testServer(t)
+	server.spProvider.GetServiceProviderConfig("https://sp.example.com").NameIDFormat = "persistent"
+
+	login := func() *saml.NameID {
+		requestID := startSSO(t, server)
+		w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+		return verifyResponse(t, server, decodeSAMLResponse(t, w.Body.String())).Subject.NameID
+	}
+
+	first := login()
+	if first.Format != string(saml.PersistentNameIDFormat) {
+		t.Errorf("Expected persistent format, got %q", first.Format)
+	}
+	if first.Value == "test@example.com" {
+		t.Error("Expected generated persistent NameID")
+	}
+	if first.NameQualifier != server.idp.MetadataURL.String() || first.SPNameQualifier != "https://sp.example.com" {
+		t.Errorf("Expected qualifiers to be populated, got %q / %q", first.NameQualifier, first.SPNameQualifier)
+	}
+	if second := login(); second.Value != first.Value {
+		t.Errorf("Expected same persistent NameID across logins, got %q and %q", first.Value, second.Value)
+	}
+}
+
+func TestResolveNameIDFormat(t *testing.T) {
+	sp := &config.ServiceProvider{NameIDFormat: "email"}
+	user := &config.User{
+		Name:    "Alice",
+		NameID:  "alice@example.com",
+		NameIDs: map[string]string{"windows": `EXAMPLE\alice`},
+	}
+	policy := func(format saml.NameIDFormat) *saml.NameIDPolicy {
+		f := string(format)
+		return &saml.NameIDPolicy{Format: &f}
+	}
+
+	tests := []struct {
+		name     string
+		policy   *saml.NameIDPolicy
+		expected saml.NameIDFormat
+		wantErr  bool
+	}{
+		{"no policy", nil, saml.EmailAddressNameIDFormat, false},
+		{"unspecified", policy(saml.UnspecifiedNameIDFormat), saml.EmailAddressNameIDFormat, false},
+		{"persistent", policy(saml.PersistentNameIDFormat), saml.PersistentNameIDFormat, false},
+		{"name_ids", policy(WindowsDomainQualifiedNameNameIDFormat), WindowsDomainQualifiedNameNameIDFormat, false},
+		{"not available", policy(KerberosNameIDFormat), "", true},
+		{"unsupported", policy("urn:example:nameid-format:custom"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := resolveNameIDFormat(tt.policy, sp, user)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidNameIDPolicy) {
+					t.Errorf("Expected errInvalidNameIDPolicy, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("resolveNameIDFormat() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoginFlowNameIDPolicy(t *testing.T) {
+	server := testServer(t)
+
+	policy := `<samlp:NameIDPolicy Format="urn:oasis:names:tc:SAML:2.0:nameid-format:persistent" ` +
+		`SPNameQualifier="https://affiliation.example.com" AllowCreate="true"/>`
+	requestID := startSSOWithPolicy(t, server, policy)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+
+	nameID := verifyResponse(t, server, decodeSAMLResponse(t, w.Body.String())).Subject.NameID
+	if nameID.Format != string(saml.PersistentNameIDFormat) {
+		t.Errorf("Expected requested persistent format, got %q", nameID.Format)
+	}
+	if nameID.SPNameQualifier != "https://affiliation.example.com" {
+		t.Errorf("Expected requested SPNameQualifier, got %q", nameID.SPNameQualifier)
+	}
+	if nameID.Value != persistentID(server.persistentIDSalt, "test@example.com", "https://affiliation.example.com") {
+		t.Error("Expected persistent NameID to be pairwise for the requested SPNameQualifier")
+	}
+}
+
+func TestLoginFlowNameIDPolicyAllowCreate(t *testing.T) {
+	server := testServer(t)
+
+	login := func(allowCreate string) string {
+		policy := `<samlp:NameIDPolicy Format="urn:oasis:names:tc:SAML:2.0:nameid-format:persistent" AllowCreate="` + allowCreate + `"/>`
+		requestID := startSSOWithPolicy(t, server, policy)
+		w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+		return decodeSAMLResponse(t, w.Body.String())
+	}
+
+	// No persistent identifier has been issued yet
+	if response := login("false"); !strings.Contains(response, saml.StatusInvalidNameIDPolicy) {
+		t.Error("Expected InvalidNameIDPolicy status when AllowCreate is false")
+	}
+
+	verifyResponse(t, server, login("true"))
+
+	if response := login("false"); strings.Contains(response, saml.StatusInvalidNameIDPolicy) {
+		t.Error("Expected existing persistent identifier to be sent when AllowCreate is false")
+	}
+}
+
+func TestSSOUnsupportedNameIDPolicy(t *testing.T) {
+	server := testServer(t)
+
+	w := postAuthnRequest(server, `<samlp:NameIDPolicy Format="urn:example:nameid-format:custom"/>`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status response form, got %d: %s", w.Code, w.Body.String())
+	}
+
+	response := decodeSAMLResponse(t, w.Body.String())
+	if !strings.Contains(response, saml.StatusInvalidNameIDPolicy) {
+		t.Error("Expected InvalidNameIDPolicy status")
+	}
+	if strings.Contains(response, "Assertion") {
+		t.Error("Expected no assertion in failure response")
+	}
+}
+
+func TestLoginFlowNameIDPolicyUnavailableFormat(t *testing.T) {
+	server := testServer(t)
+
+	requestID := startSSOWithPolicy(t, server, `<samlp:NameIDPolicy Format="urn:oasis:names:tc:SAML:2.0:nameid-format:kerberos"/>`)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+
+	if response := decodeSAMLResponse(t, w.Body.String()); !strings.Contains(response, saml.StatusInvalidNameIDPolicy) {
+		t.Error("Expected InvalidNameIDPolicy status for a format the user cannot supply")
+	}
+	if _, ok := server.sessionProvider.GetPendingRequest(requestID); ok {
+		t.Error("Expected pending request to be deleted after failure")
+	}
+}
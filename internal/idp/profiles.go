@@ -0,0 +1,193 @@
+package idp
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// AttributeMapping describes how a vendor names a neutral user attribute.
+type AttributeMapping struct {
+	Name         string
+	NameFormat   string
+	FriendlyName string
+}
+
+// AttributeProfile maps neutral user attribute names (email, firstName,
+// groups, ...) to the names, NameFormats and FriendlyNames emitted by a
+// particular IdP vendor. Attributes without a mapping are passed through.
+type AttributeProfile struct {
+	Mappings map[string]AttributeMapping
+	// ValueType overrides the xsi:type of typed values, if set.
+	ValueType string
+	// Extra adds vendor-specific attributes that are always present.
+	Extra func(ctx profileContext) []saml.Attribute
+}
+
+// profileContext holds what profile quirks need to compute extra attributes.
+type profileContext struct {
+	// User has its templated attribute values already evaluated.
+	User        *config.User
+	IDPEntityID string
+}
+
+// Claim type URIs used by Microsoft identity products.
+const (
+	claimsXMLSoap   = "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/"
+	claimsMicrosoft = "http://schemas.microsoft.com/identity/claims/"
+	claimsWS2008    = "http://schemas.microsoft.com/ws/2008/06/identity/claims/"
+)
+
+var azureProfile = &AttributeProfile{
+	Mappings: map[string]AttributeMapping{
+		"email":       {Name: claimsXMLSoap + "emailaddress"},
+		"firstName":   {Name: claimsXMLSoap + "givenname"},
+		"lastName":    {Name: claimsXMLSoap + "surname"},
+		"displayName": {Name: claimsMicrosoft + "displayname"},
+		"upn":         {Name: claimsXMLSoap + "name"},
+		"groups":      {Name: claimsWS2008 + "groups"},
+		"role":        {Name: claimsWS2008 + "role"},
+		"objectId":    {Name: claimsMicrosoft + "objectidentifier"},
+		"tenantId":    {Name: claimsMicrosoft + "tenantid"},
+		"amr":         {Name: "http://schemas.microsoft.com/claims/authnmethodsreferences"},
+	},
+	Extra: azureExtra,
+}
+
+// AttributeProfiles lists the supported attribute_profile values.
+var AttributeProfiles = map[string]*AttributeProfile{
+	"azure": azureProfile,
+	"entra": azureProfile,
+	"okta": {
+		Mappings: map[string]AttributeMapping{
+			"email":       {Name: "email", NameFormat: config.AttrNameFormatUnspecified},
+			"firstName":   {Name: "firstName", NameFormat: config.AttrNameFormatUnspecified},
+			"lastName":    {Name: "lastName", NameFormat: config.AttrNameFormatUnspecified},
+			"displayName": {Name: "displayName", NameFormat: config.AttrNameFormatUnspecified},
+			"groups":      {Name: "groups", NameFormat: config.AttrNameFormatUnspecified},
+			"role":        {Name: "role", NameFormat: config.AttrNameFormatUnspecified},
+		},
+	},
+	"adfs": {
+		Mappings: map[string]AttributeMapping{
+			"email":       {Name: claimsXMLSoap + "emailaddress"},
+			"firstName":   {Name: claimsXMLSoap + "givenname"},
+			"lastName":    {Name: claimsXMLSoap + "surname"},
+			"displayName": {Name: "http://schemas.xmlsoap.org/claims/CommonName"},
+			"upn":         {Name: claimsXMLSoap + "upn"},
+			"groups":      {Name: "http://schemas.xmlsoap.org/claims/Group"},
+			"role":        {Name: claimsWS2008 + "role"},
+		},
+	},
+	"shibboleth": {
+		Mappings: map[string]AttributeMapping{
+			"email":       {Name: "urn:oid:0.9.2342.19200300.100.1.3", NameFormat: config.AttrNameFormatURI, FriendlyName: "mail"},
+			"firstName":   {Name: "urn:oid:2.5.4.42", NameFormat: config.AttrNameFormatURI, FriendlyName: "givenName"},
+			"lastName":    {Name: "urn:oid:2.5.4.4", NameFormat: config.AttrNameFormatURI, FriendlyName: "sn"},
+			"displayName": {Name: "urn:oid:2.16.840.1.113730.3.1.241", NameFormat: config.AttrNameFormatURI, FriendlyName: "displayName"},
+			"uid":         {Name: "urn:oid:0.9.2342.19200300.100.1.1", NameFormat: config.AttrNameFormatURI, FriendlyName: "uid"},
+			"eppn":        {Name: "urn:oid:1.3.6.1.4.1.5923.1.1.1.6", NameFormat: config.AttrNameFormatURI, FriendlyName: "eduPersonPrincipalName"},
+			"groups":      {Name: "urn:oid:1.3.6.1.4.1.5923.1.5.1.1", NameFormat: config.AttrNameFormatURI, FriendlyName: "isMemberOf"},
+			"role":        {Name: "urn:oid:1.3.6.1.4.1.5923.1.1.1.7", NameFormat: config.AttrNameFormatURI, FriendlyName: "eduPersonEntitlement"},
+			"affiliation": {Name: "urn:oid:1.3.6.1.4.1.5923.1.1.1.9", NameFormat: config.AttrNameFormatURI, FriendlyName: "eduPersonScopedAffiliation"},
+		},
+	},
+	"google": {
+		Mappings: map[string]AttributeMapping{
+			"email":     {Name: "email"},
+			"firstName": {Name: "firstName"},
+			"lastName":  {Name: "lastName"},
+			"groups":    {Name: "groups"},
+		},
+		// Google Workspace emits untyped values as xs:anyType
+		ValueType: "xs:anyType",
+	},
+}
+
+// AttributeProfileNames returns the supported profile names, sorted.
+func AttributeProfileNames() []string {
+	names := make([]string, 0, len(AttributeProfiles))
+	for name := range AttributeProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyAttributeProfile renames neutral attributes according to the profile
+// and appends any vendor-specific attributes.
+func applyAttributeProfile(profile *AttributeProfile, attrs []saml.Attribute, ctx profileContext) []saml.Attribute {
+	if profile == nil {
+		return attrs
+	}
+
+	// Vendor extras are skipped when the user supplies the same attribute
+	attrs = attrs[:len(attrs):len(attrs)]
+	present := make(map[string]bool, len(attrs))
+	for _, attr := range attrs {
+		present[attr.Name] = true
+	}
+	if profile.Extra != nil {
+		for _, extra := range profile.Extra(ctx) {
+			if !present[extra.Name] {
+				attrs = append(attrs, extra)
+			}
+		}
+	}
+
+	result := make([]saml.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		if mapping, ok := profile.Mappings[attr.Name]; ok {
+			attr.Name = mapping.Name
+			attr.NameFormat = mapping.NameFormat
+			attr.FriendlyName = mapping.FriendlyName
+		}
+		result = append(result, attr)
+	}
+
+	if profile.ValueType != "" {
+		for i := range result {
+			for j := range result[i].Values {
+				if v := &result[i].Values[j]; v.Type != "" && v.Type != xsiNilType {
+					v.Type = profile.ValueType
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// azureExtra emulates the claims Entra ID always issues: the user's object
+// ID, the tenant ID, the identity provider and the UPN. Object and tenant
+// IDs are stable UUIDs derived from the NameID and IdP entity ID unless the
+// user defines objectId or tenantId attributes.
+func azureExtra(ctx profileContext) []saml.Attribute {
+	tenantID := stableUUID("tenant", ctx.IDPEntityID)
+	if v, ok := firstAttributeValue(ctx.User, "tenantId"); ok {
+		tenantID = v
+	}
+
+	return []saml.Attribute{
+		{Name: "tenantId", Values: attributeValues(tenantID)},
+		{Name: "objectId", Values: attributeValues(stableUUID("object", ctx.IDPEntityID, ctx.User.NameID))},
+		{Name: claimsMicrosoft + "identityprovider", Values: attributeValues("https://sts.windows.net/" + tenantID + "/")},
+		{Name: "upn", Values: attributeValues(ctx.User.NameID)},
+	}
+}
+
+// stableUUID derives a name-based (version 5 style) UUID from the given parts.
+func stableUUID(parts ...string) string {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	b := h.Sum(nil)[:16]
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
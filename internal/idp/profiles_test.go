@@ -0,0 +1,156 @@
+package idp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+func TestApplyAttributeProfileShibboleth(t *testing.T) {
+	attrs := []saml.Attribute{
+		{Name: "email", NameFormat: config.AttrNameFormatBasic, Values: attributeValues("a@example.com")},
+		{Name: "custom", NameFormat: config.AttrNameFormatBasic, Values: attributeValues("x")},
+	}
+
+	result := applyAttributeProfile(AttributeProfiles["shibboleth"], attrs, profileContext{User: &config.User{}})
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 attributes, got %d", len(result))
+	}
+
+	email := result[0]
+	if email.Name != "urn:oid:0.9.2342.19200300.100.1.3" || email.FriendlyName != "mail" || email.NameFormat != config.AttrNameFormatURI {
+		t.Errorf("Unexpected mapped email attribute: %+v", email)
+	}
+	if result[1].Name != "custom" || result[1].NameFormat != config.AttrNameFormatBasic {
+		t.Errorf("Expected unmapped attribute to pass through, got %+v", result[1])
+	}
+}
+
+func TestApplyAttributeProfileAzure(t *testing.T) {
+	user := &config.User{NameID: "alice@contoso.com", Attributes: map[string]interface{}{"tenantId": "tenant-123"}}
+	attrs := []saml.Attribute{
+		{Name: "tenantId", Values: attributeValues("tenant-123")},
+		{Name: "groups", Values: attributeValues([]string{"a", "b"})},
+	}
+
+	result := applyAttributeProfile(AttributeProfiles["azure"], attrs, profileContext{User: user, IDPEntityID: "https://idp.example.com"})
+
+	byName := make(map[string]saml.Attribute)
+	for _, attr := range result {
+		byName[attr.Name] = attr
+	}
+
+	if _, ok := byName[claimsWS2008+"groups"]; !ok {
+		t.Error("Expected groups claim")
+	}
+	if attr := byName[claimsMicrosoft+"tenantid"]; len(attr.Values) != 1 || attr.Values[0].Value != "tenant-123" {
+		t.Errorf("Expected user-supplied tenant ID only once, got %+v", attr)
+	}
+	if attr := byName[claimsMicrosoft+"identityprovider"]; len(attr.Values) != 1 || attr.Values[0].Value != "https://sts.windows.net/tenant-123/" {
+		t.Errorf("Unexpected identityprovider claim: %+v", attr)
+	}
+	if attr := byName[claimsXMLSoap+"name"]; len(attr.Values) != 1 || attr.Values[0].Value != "alice@contoso.com" {
+		t.Errorf("Expected UPN name claim, got %+v", attr)
+	}
+
+	objectID := byName[claimsMicrosoft+"objectidentifier"]
+	if len(objectID.Values) != 1 || len(objectID.Values[0].Value) != 36 {
+		t.Fatalf("Expected generated objectidentifier UUID, got %+v", objectID)
+	}
+
+	// Object IDs are stable across logins
+	again := applyAttributeProfile(AttributeProfiles["azure"], attrs, profileContext{User: user, IDPEntityID: "https://idp.example.com"})
+	for _, attr := range again {
+		if attr.Name == claimsMicrosoft+"objectidentifier" && attr.Values[0].Value != objectID.Values[0].Value {
+			t.Error("Expected stable objectidentifier")
+		}
+	}
+
+	// tenantId, groups, objectidentifier, identityprovider, name
+	if len(result) != 5 {
+		t.Errorf("Expected 5 attributes, got %d", len(result))
+	}
+}
+
+func TestAzureTenantIDAttributeForms(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"string", "tenant-123"},
+		{"list", []interface{}{"tenant-123", "other"}},
+		{"declaration", map[string]interface{}{"value": "tenant-123", "name_format": "uri"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &config.User{NameID: "alice@contoso.com", Attributes: map[string]interface{}{"tenantId": tt.value}}
+			for _, attr := range azureExtra(profileContext{User: user, IDPEntityID: "https://idp.example.com"}) {
+				if attr.Name == claimsMicrosoft+"identityprovider" && attr.Values[0].Value != "https://sts.windows.net/tenant-123/" {
+					t.Errorf("Expected the tenant ID value, got %s", attr.Values[0].Value)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginFlowAzureTemplatedTenantID(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.AttributeProfile = "azure"
+	sp.Users[0].Attributes["tenantId"] = "{{ .User.NameID }}"
+
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	response := decodeSAMLResponse(t, w.Body.String())
+	if !strings.Contains(response, ">https://sts.windows.net/test@example.com/<") {
+		t.Errorf("Expected the rendered tenant ID in the identityprovider claim, got %s", response)
+	}
+}
+
+func TestApplyAttributeProfileGoogleValueType(t *testing.T) {
+	attrs := []saml.Attribute{{Name: "email", Values: attributeValues("a@example.com")}}
+	result := applyAttributeProfile(AttributeProfiles["google"], attrs, profileContext{User: &config.User{}})
+	if result[0].Values[0].Type != "xs:anyType" {
+		t.Errorf("Expected xs:anyType, got %q", result[0].Values[0].Type)
+	}
+}
+
+func TestStableUUID(t *testing.T) {
+	a := stableUUID("x", "y")
+	if a != stableUUID("x", "y") {
+		t.Error("Expected stable UUID")
+	}
+	if a == stableUUID("xy") {
+		t.Error("Expected parts to be separated")
+	}
+	if len(a) != 36 || a[14] != '5' {
+		t.Errorf("Expected version 5 UUID, got %s", a)
+	}
+}
+
+func TestUnknownAttributeProfile(t *testing.T) {
+	sps := []config.ServiceProvider{{EntityID: "https://sp.example.com", ACSURL: "https://sp.example.com/acs", AttributeProfile: "pingfederate"}}
+	if _, err := NewServiceProviderProvider(sps); err == nil {
+		t.Error("Expected error for unknown attribute profile")
+	}
+}
+
+func TestLoginFlowAttributeProfile(t *testing.T) {
+	server := testServer(t)
+	server.spProvider.GetServiceProviderConfig("https://sp.example.com").AttributeProfile = "azure"
+
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	response := decodeSAMLResponse(t, w.Body.String())
+	verifyResponse(t, server, response)
+
+	if !strings.Contains(response, claimsXMLSoap+"emailaddress") {
+		t.Error("Expected Azure email claim in response")
+	}
+	if !strings.Contains(response, claimsMicrosoft+"objectidentifier") {
+		t.Error("Expected Azure objectidentifier claim in response")
+	}
+}
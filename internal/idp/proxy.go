@@ -0,0 +1,77 @@
+package idp
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/crewjam/saml"
+)
+
+// path returns the URL path of a route under the base URL's path.
+func (s *Server) path(route string) string {
+	return s.basePath + route
+}
+
+// pattern returns the ServeMux pattern of a route.
+func (s *Server) pattern(route string) string {
+	return s.routeHost + s.path(route)
+}
+
+// requestIDP returns the IDP for a request. Behind a trusted proxy, its
+// URLs use the scheme and host the client connected to.
+func (s *Server) requestIDP(r *http.Request) *saml.IdentityProvider {
+	if !s.config.Server.TrustsProxy(r.RemoteAddr) {
+		return s.idp
+	}
+	scheme, host := forwardedOrigin(r)
+	if scheme == "" && host == "" {
+		return s.idp
+	}
+
+	idp := *s.idp
+	if scheme != "" {
+		idp.MetadataURL.Scheme, idp.SSOURL.Scheme = scheme, scheme
+	}
+	if host != "" {
+		idp.MetadataURL.Host, idp.SSOURL.Host = host, host
+	}
+	return &idp
+}
+
+// forwardedOrigin returns the original scheme and host of a proxied
+// request from the Forwarded header, or else X-Forwarded-Proto and
+// X-Forwarded-Host. Either may be empty if the proxy didn't send it.
+func forwardedOrigin(r *http.Request) (scheme, host string) {
+	if forwarded := r.Header.Get("Forwarded"); forwarded != "" {
+		// Only the first element, added by the proxy nearest the client
+		first, _, _ := strings.Cut(forwarded, ",")
+		for _, pair := range strings.Split(first, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(value, `"`)
+			switch strings.ToLower(key) {
+			case "proto":
+				scheme = value
+			case "host":
+				host = value
+			}
+		}
+	} else {
+		scheme = firstHeaderValue(r, "X-Forwarded-Proto")
+		host = firstHeaderValue(r, "X-Forwarded-Host")
+	}
+
+	scheme = strings.ToLower(scheme)
+	if scheme != "http" && scheme != "https" {
+		scheme = ""
+	}
+	return scheme, host
+}
+
+// firstHeaderValue returns the first of a header's comma-separated values.
+func firstHeaderValue(r *http.Request, name string) string {
+	value, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,129 @@
+package idp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveMux sends a request through the server's registered routes.
+func serveMux(server *Server, req *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestPathPrefix(t *testing.T) {
+	server := testServerAt(t, "http://localhost:8080/tools/idp/")
+
+	w := serveMux(server, httptest.NewRequest("GET", "/tools/idp/metadata", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for prefixed metadata, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `Location="http://localhost:8080/tools/idp/sso"`) {
+		t.Errorf("Expected prefixed SSO URL in metadata: %s", w.Body.String())
+	}
+	if w := serveMux(server, httptest.NewRequest("GET", "/metadata", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 outside the prefix, got %d", w.Code)
+	}
+
+	authnRequest := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" `+
+		`xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-test-request" Version="2.0" `+
+		`IssueInstant="%s" Destination="http://localhost:8080/tools/idp/sso" AssertionConsumerServiceURL="https://sp.example.com/acs">`+
+		`<saml:Issuer>https://sp.example.com</saml:Issuer></samlp:AuthnRequest>`,
+		time.Now().UTC().Format(time.RFC3339))
+	form := url.Values{"SAMLRequest": {base64.StdEncoding.EncodeToString([]byte(authnRequest))}}
+	req := httptest.NewRequest("POST", "/tools/idp/sso", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w = serveMux(server, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status 302 from /sso, got %d: %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/tools/idp/login?request_id=") {
+		t.Fatalf("Expected redirect to prefixed login page, got %s", location)
+	}
+
+	w = serveMux(server, httptest.NewRequest("GET", location, nil))
+	if !strings.Contains(w.Body.String(), `action="login?request_id=`) {
+		t.Errorf("Expected login form to post relative to the prefix: %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", location, strings.NewReader(url.Values{"user": {"Test User"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = serveMux(server, req)
+	response := decodeSAMLResponse(t, w.Body.String())
+	if !strings.Contains(response, "http://localhost:8080/tools/idp/metadata</saml:Issuer>") {
+		t.Errorf("Expected prefixed issuer in response: %s", response)
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	server := testServer(t)
+
+	metadata := func(headers map[string]string) string {
+		req := httptest.NewRequest("GET", "/metadata", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.handleMetadata(w, req)
+		return w.Body.String()
+	}
+	xForwarded := map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "idp.example.com"}
+
+	// httptest requests come from 192.0.2.1, which isn't trusted yet
+	if body := metadata(xForwarded); !strings.Contains(body, `Location="http://localhost:8080/sso"`) {
+		t.Errorf("Expected headers from an untrusted client to be ignored: %s", body)
+	}
+
+	server.config.Server.TrustedProxies = []string{"192.0.2.0/24"}
+	if body := metadata(xForwarded); !strings.Contains(body, `entityID="https://idp.example.com/metadata"`) ||
+		!strings.Contains(body, `Location="https://idp.example.com/sso"`) {
+		t.Errorf("Expected X-Forwarded-* URLs in metadata: %s", body)
+	}
+	if body := metadata(map[string]string{"Forwarded": `for=198.51.100.7;proto=https;host="sso.example.com:8443", for=10.0.0.1`}); !strings.Contains(body, `Location="https://sso.example.com:8443/sso"`) {
+		t.Errorf("Expected Forwarded URLs in metadata: %s", body)
+	}
+
+	// The server's own URLs are unchanged
+	if got := server.idp.SSOURL.String(); got != "http://localhost:8080/sso" {
+		t.Errorf("Expected server SSO URL to be unchanged, got %s", got)
+	}
+}
+
+func TestForwardedOrigin(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantScheme string
+		wantHost   string
+	}{
+		{"none", nil, "", ""},
+		{"x-forwarded", map[string]string{"X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "a.example.com, b.internal"}, "https", "a.example.com"},
+		{"forwarded", map[string]string{"Forwarded": `Proto=HTTPS;Host=a.example.com`}, "https", "a.example.com"},
+		{"forwarded wins", map[string]string{"Forwarded": "proto=https", "X-Forwarded-Host": "ignored.example.com"}, "https", ""},
+		{"unknown scheme", map[string]string{"X-Forwarded-Proto": "ftp"}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metadata", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			scheme, host := forwardedOrigin(req)
+			if scheme != tt.wantScheme || host != tt.wantHost {
+				t.Errorf("forwardedOrigin() = %q, %q, want %q, %q", scheme, host, tt.wantScheme, tt.wantHost)
+			}
+		})
+	}
+}
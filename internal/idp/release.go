@@ -0,0 +1,148 @@
+package idp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// releasePolicy is a compiled config.ReleasePolicy.
+type releasePolicy struct {
+	allow         map[string]bool
+	deny          map[string]bool
+	values        map[string]*regexp.Regexp
+	requestedOnly bool
+	// requested is non-nil when only requested attributes are released.
+	requested map[string]bool
+}
+
+// compileReleasePolicy compiles an SP's release policy once, returning nil
+// if the SP has none.
+func compileReleasePolicy(policy *config.ReleasePolicy) (*releasePolicy, error) {
+	if policy == nil {
+		return nil, nil
+	}
+
+	p := &releasePolicy{
+		deny:          stringSet(policy.Deny),
+		values:        make(map[string]*regexp.Regexp, len(policy.Values)),
+		requestedOnly: policy.RequestedOnly,
+	}
+	if len(policy.Allow) > 0 {
+		p.allow = stringSet(policy.Allow)
+	}
+	for name, pattern := range policy.Values {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("attribute_release: values: %s: %w", name, err)
+		}
+		p.values[name] = re
+	}
+	return p, nil
+}
+
+// forRequest returns the policy applied to a request, which limits it to
+// the requested attributes when only those are released.
+func (p *releasePolicy) forRequest(req *saml.IdpAuthnRequest) *releasePolicy {
+	if p == nil || !p.requestedOnly {
+		return p
+	}
+
+	scoped := *p
+	scoped.requested = make(map[string]bool)
+	if service := attributeConsumingService(req); service != nil {
+		for _, attr := range service.RequestedAttributes {
+			scoped.requested[attr.Name] = true
+			if attr.FriendlyName != "" {
+				scoped.requested[attr.FriendlyName] = true
+			}
+		}
+	}
+	return &scoped
+}
+
+// attributeConsumingService returns the AttributeConsumingService selected by
+// the AuthnRequest's index, else the default (or first) one in the SP's
+// metadata, or nil if the SP has none.
+func attributeConsumingService(req *saml.IdpAuthnRequest) *saml.AttributeConsumingService {
+	if req.SPSSODescriptor == nil || len(req.SPSSODescriptor.AttributeConsumingServices) == 0 {
+		return nil
+	}
+	services := req.SPSSODescriptor.AttributeConsumingServices
+
+	if index := req.Request.AttributeConsumingServiceIndex; index != "" {
+		for i := range services {
+			if strconv.Itoa(services[i].Index) == index {
+				return &services[i]
+			}
+		}
+	}
+	for i := range services {
+		if services[i].IsDefault != nil && *services[i].IsDefault {
+			return &services[i]
+		}
+	}
+	return &services[0]
+}
+
+// filter returns the attributes the policy allows to be released.
+func (p *releasePolicy) filter(attrs []saml.Attribute) []saml.Attribute {
+	if p == nil {
+		return attrs
+	}
+
+	result := make([]saml.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		if p.allow != nil && !matchesAttribute(p.allow, attr) {
+			continue
+		}
+		if matchesAttribute(p.deny, attr) {
+			continue
+		}
+		if p.requested != nil && !matchesAttribute(p.requested, attr) {
+			continue
+		}
+
+		if re := p.valuePattern(attr); re != nil {
+			values := make([]saml.AttributeValue, 0, len(attr.Values))
+			for _, v := range attr.Values {
+				if re.MatchString(v.Value) {
+					values = append(values, v)
+				}
+			}
+			if len(values) == 0 {
+				continue
+			}
+			attr.Values = values
+		}
+		result = append(result, attr)
+	}
+	return result
+}
+
+// valuePattern returns the value pattern for an attribute, if any.
+func (p *releasePolicy) valuePattern(attr saml.Attribute) *regexp.Regexp {
+	if re, ok := p.values[attr.Name]; ok {
+		return re
+	}
+	if attr.FriendlyName != "" {
+		return p.values[attr.FriendlyName]
+	}
+	return nil
+}
+
+// matchesAttribute reports whether an attribute's Name or FriendlyName is in names.
+func matchesAttribute(names map[string]bool, attr saml.Attribute) bool {
+	return names[attr.Name] || (attr.FriendlyName != "" && names[attr.FriendlyName])
+}
+
+func stringSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
@@ -0,0 +1,163 @@
+package idp
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// requestReleasePolicy compiles a release policy and applies it to req.
+func requestReleasePolicy(t *testing.T, policy *config.ReleasePolicy, req *saml.IdpAuthnRequest) *releasePolicy {
+	t.Helper()
+
+	p, err := compileReleasePolicy(policy)
+	if err != nil {
+		t.Fatalf("compileReleasePolicy failed: %v", err)
+	}
+	return p.forRequest(req)
+}
+
+func TestReleasePolicyFilter(t *testing.T) {
+	attrs := []saml.Attribute{
+		{Name: "email", FriendlyName: "email", Values: attributeValues("alice@example.com")},
+		{Name: "urn:oid:2.5.4.42", FriendlyName: "givenName", Values: attributeValues("Alice")},
+		{Name: "groups", FriendlyName: "groups", Values: attributeValues([]interface{}{"app-admins", "staff", "app-users"})},
+		{Name: "ssn", FriendlyName: "ssn", Values: attributeValues("123-45-6789")},
+		{Name: "role", FriendlyName: "role", Values: attributeValues("staff")},
+	}
+	req := &saml.IdpAuthnRequest{Request: saml.AuthnRequest{}}
+
+	names := func(attrs []saml.Attribute) []string {
+		out := []string{}
+		for _, attr := range attrs {
+			out = append(out, attr.Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		policy   *config.ReleasePolicy
+		expected []string
+	}{
+		{"no policy", nil, []string{"email", "urn:oid:2.5.4.42", "groups", "ssn", "role"}},
+		{"allow", &config.ReleasePolicy{Allow: []string{"email", "givenName"}}, []string{"email", "urn:oid:2.5.4.42"}},
+		{"deny", &config.ReleasePolicy{Deny: []string{"ssn"}}, []string{"email", "urn:oid:2.5.4.42", "groups", "role"}},
+		{"allow and deny", &config.ReleasePolicy{Allow: []string{"email", "ssn"}, Deny: []string{"ssn"}}, []string{"email"}},
+		{"values", &config.ReleasePolicy{Values: map[string]string{"role": "^admin$"}}, []string{"email", "urn:oid:2.5.4.42", "groups", "ssn"}},
+		{"requested only without metadata", &config.ReleasePolicy{RequestedOnly: true}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := requestReleasePolicy(t, tt.policy, req).filter(attrs)
+			if got := names(result); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("filter() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	// Only matching values are released
+	result := requestReleasePolicy(t, &config.ReleasePolicy{Values: map[string]string{"groups": "^app-"}}, req).filter(attrs)
+	for _, attr := range result {
+		if attr.Name != "groups" {
+			continue
+		}
+		if len(attr.Values) != 2 || attr.Values[0].Value != "app-admins" || attr.Values[1].Value != "app-users" {
+			t.Errorf("Expected only app- groups, got %v", attr.Values)
+		}
+	}
+	if len(attrs[2].Values) != 3 {
+		t.Error("Expected filter not to modify its input")
+	}
+}
+
+func TestReleasePolicyRequestedOnly(t *testing.T) {
+	isDefault := true
+	req := &saml.IdpAuthnRequest{
+		SPSSODescriptor: &saml.SPSSODescriptor{
+			AttributeConsumingServices: []saml.AttributeConsumingService{
+				{Index: 1, RequestedAttributes: []saml.RequestedAttribute{
+					{Attribute: saml.Attribute{Name: "role"}},
+				}},
+				{Index: 2, IsDefault: &isDefault, RequestedAttributes: []saml.RequestedAttribute{
+					{Attribute: saml.Attribute{Name: "urn:oid:0.9.2342.19200300.100.1.3", FriendlyName: "email"}},
+				}},
+			},
+		},
+	}
+	attrs := []saml.Attribute{
+		{Name: "email", FriendlyName: "email", Values: attributeValues("alice@example.com")},
+		{Name: "role", FriendlyName: "role", Values: attributeValues("staff")},
+	}
+	policy := &config.ReleasePolicy{RequestedOnly: true}
+
+	// The default service is used without an index in the request
+	if result := requestReleasePolicy(t, policy, req).filter(attrs); len(result) != 1 || result[0].Name != "email" {
+		t.Errorf("Expected only the requested email attribute, got %v", result)
+	}
+
+	req.Request.AttributeConsumingServiceIndex = "1"
+	if result := requestReleasePolicy(t, policy, req).filter(attrs); len(result) != 1 || result[0].Name != "role" {
+		t.Errorf("Expected only the requested role attribute, got %v", result)
+	}
+}
+
+func TestCompileReleasePolicyInvalidPattern(t *testing.T) {
+	sps := []config.ServiceProvider{{
+		EntityID:         "https://sp.example.com",
+		ACSURL:           "https://sp.example.com/acs",
+		AttributeRelease: &config.ReleasePolicy{Values: map[string]string{"groups": "("}},
+	}}
+	if _, err := NewServiceProviderProvider(sps); err == nil {
+		t.Error("Expected error for an invalid value pattern")
+	}
+}
+
+func TestLoginFlowAttributeRelease(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.Users[0].Attributes["ssn"] = "123-45-6789"
+	sp.AttributeRelease = &config.ReleasePolicy{Deny: []string{"ssn", "uid"}}
+	provider, err := NewServiceProviderProvider(server.config.ServiceProviders)
+	if err != nil {
+		t.Fatalf("Failed to create SP provider: %v", err)
+	}
+	server.spProvider = provider
+
+	requestID := startSSO(t, server)
+
+	// The login page previews the released attributes
+	req := httptest.NewRequest("GET", "/login?request_id="+requestID, nil)
+	w := httptest.NewRecorder()
+	server.handleLogin(w, req)
+	page := w.Body.String()
+	if !strings.Contains(page, "<code>email</code>") {
+		t.Error("Expected login page to list the email attribute")
+	}
+	if strings.Contains(page, "<code>ssn</code>") || strings.Contains(page, "<code>uid (") {
+		t.Error("Expected login page not to list denied attributes")
+	}
+
+	w = postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	assertion := verifyResponse(t, server, decodeSAMLResponse(t, w.Body.String()))
+
+	released := map[string]bool{}
+	for _, stmt := range assertion.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			released[attr.Name] = true
+			released[attr.FriendlyName] = true
+		}
+	}
+	if !released["email"] {
+		t.Error("Expected email attribute to be released")
+	}
+	if released["ssn"] || released["uid"] {
+		t.Errorf("Expected denied attributes to be withheld, got %v", released)
+	}
+}
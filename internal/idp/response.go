@@ -0,0 +1,334 @@
+package idp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/breakroom/saml-test-idp/internal/web"
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/xmlenc"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// assertionMaker wraps saml.DefaultAssertionMaker and applies the
+// issuer, assertion ID, authentication context, NameID qualifier and
+// attribute release policy established during login.
+type assertionMaker struct {
+	issuer               string
+	assertionID          string
+	authnContextClassRef string
+	spNameQualifier      string
+	release              *releasePolicy
+	timing               *assertionTiming
+	// omitAddress leaves out the client address the library copies from
+	// the request, which differs between otherwise identical logins
+	omitAddress bool
+}
+
+// MakeAssertion implements saml.AssertionMaker.
+func (m assertionMaker) MakeAssertion(req *saml.IdpAuthnRequest, session *saml.Session) error {
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		return err
+	}
+
+	// The library reads the wall clock and a global random source for these
+	req.Assertion.IssueInstant = req.Now
+	if m.issuer != "" {
+		req.Assertion.Issuer.Value = m.issuer
+		if req.Assertion.Subject != nil && req.Assertion.Subject.NameID != nil {
+			req.Assertion.Subject.NameID.NameQualifier = m.issuer
+		}
+	}
+	if m.assertionID != "" {
+		req.Assertion.ID = m.assertionID
+	}
+	if m.timing != nil {
+		m.timing.apply(req.Assertion, req.Now)
+	}
+	if m.omitAddress {
+		if subject := req.Assertion.Subject; subject != nil {
+			for i := range subject.SubjectConfirmations {
+				if data := subject.SubjectConfirmations[i].SubjectConfirmationData; data != nil {
+					data.Address = ""
+				}
+			}
+		}
+		for i := range req.Assertion.AuthnStatements {
+			req.Assertion.AuthnStatements[i].SubjectLocality = nil
+		}
+	}
+
+	if m.spNameQualifier != "" && req.Assertion.Subject != nil && req.Assertion.Subject.NameID != nil {
+		req.Assertion.Subject.NameID.SPNameQualifier = m.spNameQualifier
+	}
+
+	// The release policy also covers attributes added by the saml library
+	if m.release != nil {
+		statements := req.Assertion.AttributeStatements[:0]
+		for _, stmt := range req.Assertion.AttributeStatements {
+			stmt.Attributes = m.release.filter(stmt.Attributes)
+			if len(stmt.Attributes) > 0 {
+				statements = append(statements, stmt)
+			}
+		}
+		req.Assertion.AttributeStatements = statements
+	}
+
+	if m.authnContextClassRef != "" {
+		for i := range req.Assertion.AuthnStatements {
+			req.Assertion.AuthnStatements[i].AuthnContext.AuthnContextClassRef = &saml.AuthnContextClassRef{
+				Value: m.authnContextClassRef,
+			}
+		}
+	}
+	return nil
+}
+
+// signingContext returns a signing context matching the one the saml
+// library uses for successful responses.
+func (s *Server) signingContext() (*dsig.SigningContext, error) {
+	keyStore := dsig.TLSCertKeyStore(tls.Certificate{
+		Certificate: [][]byte{s.certificate.Raw},
+		PrivateKey:  s.privateKey,
+		Leaf:        s.certificate,
+	})
+
+	signingContext := dsig.NewDefaultSigningContext(keyStore)
+	signingContext.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	signatureMethod := s.idp.SignatureMethod
+	if signatureMethod == "" {
+		signatureMethod = dsig.RSASHA1SignatureMethod
+	}
+	if err := signingContext.SetSignatureMethod(signatureMethod); err != nil {
+		return nil, err
+	}
+	return signingContext, nil
+}
+
+// xsiNilType marks an AttributeValue to be emitted with xsi:nil="true".
+// saml.AttributeValue cannot express nil values (or omit xsi:type), so
+// fixAttributeValues rewrites the generated element before signing.
+const xsiNilType = "xsi:nil"
+
+// fixAttributeValues removes empty xsi:type attributes and rewrites values
+// marked with xsiNilType as nil values.
+func fixAttributeValues(assertionEl *etree.Element) {
+	for _, valueEl := range assertionEl.FindElements("./saml:AttributeStatement/saml:Attribute/saml:AttributeValue") {
+		typeAttr := valueEl.SelectAttr("xsi:type")
+		if typeAttr == nil {
+			continue
+		}
+		switch typeAttr.Value {
+		case "":
+			valueEl.RemoveAttr("xsi:type")
+		case xsiNilType:
+			valueEl.RemoveAttr("xsi:type")
+			valueEl.CreateAttr("xsi:nil", "true")
+		}
+	}
+}
+
+// makeAssertionEl signs (and, if the SP has an encryption key, encrypts)
+// req.Assertion and assigns it to req.AssertionEl. It mirrors
+// saml.IdpAuthnRequest.MakeAssertionEl but applies fixAttributeValues to
+// the element before it is signed.
+func (s *Server) makeAssertionEl(req *saml.IdpAuthnRequest) error {
+	signingContext, err := s.signingContext()
+	if err != nil {
+		return err
+	}
+
+	assertionEl := req.Assertion.Element()
+	fixAttributeValues(assertionEl)
+
+	start := time.Now()
+	signedAssertionEl, err := signingContext.SignEnveloped(assertionEl)
+	s.metrics.observeSigning(time.Since(start))
+	if err != nil {
+		return err
+	}
+
+	// Regenerate the element so the signature is placed after the Issuer
+	sigEl := signedAssertionEl.Child[len(signedAssertionEl.Child)-1]
+	req.Assertion.Signature = sigEl.(*etree.Element)
+	signedAssertionEl = req.Assertion.Element()
+	fixAttributeValues(signedAssertionEl)
+
+	cert, err := spEncryptionCert(req.SPSSODescriptor)
+	if err != nil {
+		return err
+	}
+	if cert == nil {
+		req.AssertionEl = signedAssertionEl
+		return nil
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(signedAssertionEl)
+	signedAssertionBuf, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+
+	encryptor := xmlenc.OAEP()
+	encryptor.BlockCipher = xmlenc.AES128CBC
+	encryptor.DigestMethod = &xmlenc.SHA1
+	encryptedDataEl, err := encryptor.Encrypt(cert, signedAssertionBuf, nil)
+	if err != nil {
+		return err
+	}
+	encryptedDataEl.CreateAttr("Type", "http://www.w3.org/2001/04/xmlenc#Element")
+
+	encryptedAssertionEl := etree.NewElement("saml:EncryptedAssertion")
+	encryptedAssertionEl.AddChild(encryptedDataEl)
+	req.AssertionEl = encryptedAssertionEl
+	return nil
+}
+
+// spEncryptionCert returns the SP's encryption certificate, preferring keys
+// marked for encryption over unmarked ones, or nil if it has none.
+func spEncryptionCert(descriptor *saml.SPSSODescriptor) (*x509.Certificate, error) {
+	var certStr string
+	for _, use := range []string{"encryption", ""} {
+		for _, keyDescriptor := range descriptor.KeyDescriptors {
+			certs := keyDescriptor.KeyInfo.X509Data.X509Certificates
+			if keyDescriptor.Use == use && len(certs) != 0 && certs[0].Data != "" {
+				certStr = certs[0].Data
+				break
+			}
+		}
+		if certStr != "" {
+			break
+		}
+	}
+	if certStr == "" {
+		return nil, nil
+	}
+
+	certBytes, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certStr), ""))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode SP certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse SP certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// makeResponse builds a signed Response element carrying the status and,
+// if not nil, the (signed or encrypted) assertion element. Without an
+// assertion it reports failures such as AuthnFailed or RequestDenied.
+// It mirrors saml.IdpAuthnRequest.MakeResponse but takes the response ID
+// from the server. The response is issued at req.Now.
+func (s *Server) makeResponse(req *saml.IdpAuthnRequest, status saml.Status, assertionEl *etree.Element) (*etree.Element, error) {
+	response := &saml.Response{
+		Destination:  req.ACSEndpoint.Location,
+		ID:           fmt.Sprintf("id-%s", s.ids.HexID(40)),
+		InResponseTo: req.Request.ID,
+		IssueInstant: req.Now,
+		Version:      "2.0",
+		Issuer: &saml.Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  s.entityID(req.IDP),
+		},
+		Status: status,
+	}
+
+	signingContext, err := s.signingContext()
+	if err != nil {
+		return nil, err
+	}
+
+	responseEl := response.Element()
+	if assertionEl != nil {
+		responseEl.AddChild(assertionEl)
+	}
+
+	start := time.Now()
+	signedEl, err := signingContext.SignEnveloped(responseEl)
+	s.metrics.observeSigning(time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	response.Signature = signedEl.ChildElements()[len(signedEl.ChildElements())-1]
+
+	responseEl = response.Element()
+	if assertionEl != nil {
+		responseEl.AddChild(assertionEl)
+	}
+	return responseEl, nil
+}
+
+// writeStatusResponse sends a signed, assertion-less Response carrying the
+// given second-level status code to the SP's ACS endpoint, or shows it on
+// the debug page first. pendingSession is nil before the login page.
+func (s *Server) writeStatusResponse(w http.ResponseWriter, req *saml.IdpAuthnRequest, pendingSession *SessionData, topLevel, secondLevel, message string) error {
+	status := saml.Status{
+		StatusCode: saml.StatusCode{
+			Value:      topLevel,
+			StatusCode: &saml.StatusCode{Value: secondLevel},
+		},
+	}
+	if message != "" {
+		status.StatusMessage = &saml.StatusMessage{Value: message}
+	}
+
+	req.Now = s.clock.Now().Add(s.clockOffset(req, pendingSession))
+	responseEl, err := s.makeResponse(req, status, nil)
+	if err != nil {
+		return err
+	}
+
+	form, err := postBindingForm(req, responseEl)
+	if err != nil {
+		return err
+	}
+
+	debug, user := s.config.Server.Debug, ""
+	if pendingSession != nil {
+		debug, user = pendingSession.Debug, pendingSession.UserName
+	}
+	s.recordResponse(req, form, user)
+
+	if debug {
+		return s.writeDebugPage(w, req, form)
+	}
+
+	tmpl, err := template.ParseFS(web.Assets, "templates/post.html")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return tmpl.Execute(w, form)
+}
+
+// postBindingForm builds the HTTP-POST binding form carrying the response
+// element to the SP's ACS endpoint.
+func postBindingForm(req *saml.IdpAuthnRequest, responseEl *etree.Element) (*saml.IdpAuthnRequestForm, error) {
+	if req.ACSEndpoint.Binding != saml.HTTPPostBinding {
+		return nil, fmt.Errorf("%s: unsupported binding %s", req.ServiceProviderMetadata.EntityID, req.ACSEndpoint.Binding)
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(responseEl)
+	responseBuf, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.IdpAuthnRequestForm{
+		URL:          req.ACSEndpoint.Location,
+		SAMLResponse: base64.StdEncoding.EncodeToString(responseBuf),
+		RelayState:   req.RelayState,
+	}, nil
+}
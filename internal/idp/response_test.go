@@ -0,0 +1,77 @@
+package idp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+)
+
+func TestFixAttributeValues(t *testing.T) {
+	assertion := &saml.Assertion{
+		AttributeStatements: []saml.AttributeStatement{{
+			Attributes: []saml.Attribute{{
+				Name: "attr",
+				Values: []saml.AttributeValue{
+					{Type: "xs:string", Value: "typed"},
+					{Type: "", Value: "untyped"},
+					{Type: xsiNilType},
+				},
+			}},
+		}},
+	}
+
+	el := assertion.Element()
+	fixAttributeValues(el)
+
+	values := el.FindElements("./saml:AttributeStatement/saml:Attribute/saml:AttributeValue")
+	if len(values) != 3 {
+		t.Fatalf("Expected 3 values, got %d", len(values))
+	}
+	if values[0].SelectAttrValue("xsi:type", "") != "xs:string" {
+		t.Error("Expected typed value to keep xsi:type")
+	}
+	if values[1].SelectAttr("xsi:type") != nil {
+		t.Error("Expected untyped value to have no xsi:type")
+	}
+	if values[2].SelectAttr("xsi:type") != nil || values[2].SelectAttrValue("xsi:nil", "") != "true" {
+		t.Error("Expected nil value to have xsi:nil and no xsi:type")
+	}
+}
+
+func TestLoginFlowTypedAttributes(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.Users[0].Attributes["age"] = map[string]interface{}{"type": "xs:integer", "value": 42}
+	sp.Users[0].Attributes["middleName"] = map[string]interface{}{"value": nil}
+
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	response := decodeSAMLResponse(t, w.Body.String())
+
+	// The signature must still verify after the attribute fixups
+	verifyResponse(t, server, response)
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	for _, attrEl := range doc.FindElements("//Attribute") {
+		valueEl := attrEl.FindElement("./AttributeValue")
+		switch attrEl.SelectAttrValue("Name", "") {
+		case "age":
+			if valueEl.SelectAttrValue("xsi:type", "") != "xs:integer" || valueEl.Text() != "42" {
+				t.Errorf("Expected xs:integer 42, got %s", valueEl.Text())
+			}
+		case "middleName":
+			if valueEl.SelectAttrValue("xsi:nil", "") != "true" {
+				t.Error("Expected xsi:nil on middleName")
+			}
+		}
+	}
+	if !strings.Contains(response, `xsi:nil="true"`) {
+		t.Error("Expected nil attribute value in response")
+	}
+}
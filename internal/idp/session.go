@@ -1,7 +1,9 @@
 package idp
 
 import (
+	"log/slog"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,12 +12,24 @@ import (
 	"github.com/spf13/cast"
 )
 
+// Pending request defaults, used when the configuration doesn't set them.
+const (
+	DefaultPendingTTL           = 10 * time.Minute
+	DefaultPendingSweepInterval = time.Minute
+	DefaultMaxPendingRequests   = 1000
+)
+
 // SessionProvider manages pending SAML requests during the login flow.
 // Note: This IDP intentionally does not persist user sessions - each SSO
 // request shows the login page to allow selecting different test users.
 type SessionProvider struct {
 	mu              sync.RWMutex
 	pendingRequests map[string]*SessionData
+	ttl             time.Duration
+	maxPending      int
+
+	stopSweeper chan struct{}
+	sweeperDone chan struct{}
 }
 
 // SessionData holds pending SAML request information.
@@ -25,12 +39,37 @@ type SessionData struct {
 	CreateTime  time.Time
 	ExpireTime  time.Time
 	SAMLRequest *saml.IdpAuthnRequest
+	// AwaitingConsent is set once the user has authenticated and
+	// SAMLRequest.Assertion holds the assertion shown on the consent page.
+	AwaitingConsent bool
+	// Debug shows the response on a preview page instead of auto-posting it.
+	Debug bool
+	// UserName is the name of the user selected on the login page.
+	UserName string
+	// MFAUser is the name of the user who passed the login step and is
+	// being challenged for a second factor.
+	MFAUser string
+	// CustomIdentity marks an ad-hoc identity entered on the login page,
+	// whose attribute values are sent as entered rather than as templates.
+	CustomIdentity bool
+	// ClockOffset, if set, overrides the SP's clock_offset for this login.
+	ClockOffset *time.Duration
 }
 
-// NewSessionProvider creates a new session provider.
-func NewSessionProvider() *SessionProvider {
+// NewSessionProvider creates a new session provider whose pending requests
+// expire after ttl, holding at most maxPending of them. Zero values use the
+// defaults.
+func NewSessionProvider(ttl time.Duration, maxPending int) *SessionProvider {
+	if ttl <= 0 {
+		ttl = DefaultPendingTTL
+	}
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPendingRequests
+	}
 	return &SessionProvider{
 		pendingRequests: make(map[string]*SessionData),
+		ttl:             ttl,
+		maxPending:      maxPending,
 	}
 }
 
@@ -41,18 +80,37 @@ func (sp *SessionProvider) GetSession(w http.ResponseWriter, r *http.Request, re
 	return nil
 }
 
-// buildCustomAttributes converts user attributes to SAML attributes.
+// buildCustomAttributes converts user attributes to SAML attributes, in
+// name order so responses are reproducible.
 func buildCustomAttributes(user *config.User) []saml.Attribute {
 	if user == nil || user.Attributes == nil {
 		return nil
 	}
 
+	names := make([]string, 0, len(user.Attributes))
+	for name := range user.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
 	attrs := make([]saml.Attribute, 0, len(user.Attributes))
-	for name, value := range user.Attributes {
+	for _, name := range names {
+		value := user.Attributes[name]
+		// Attributes declared as a map control their NameFormat and types
+		if decl, ok := value.(map[string]interface{}); ok {
+			attr, err := declaredAttribute(name, decl)
+			if err != nil {
+				slog.Warn("Skipping attribute", "user", user.Name, "attribute", name, "err", err)
+				continue
+			}
+			attrs = append(attrs, attr)
+			continue
+		}
+
 		attr := saml.Attribute{
 			FriendlyName: name,
 			Name:         name,
-			NameFormat:   "urn:oasis:names:tc:SAML:2.0:attrname-format:basic",
+			NameFormat:   config.AttrNameFormatBasic,
 			Values:       attributeValues(value),
 		}
 		attrs = append(attrs, attr)
@@ -60,8 +118,60 @@ func buildCustomAttributes(user *config.User) []saml.Attribute {
 	return attrs
 }
 
+// declaredAttribute converts the map form of a user attribute.
+func declaredAttribute(name string, decl map[string]interface{}) (saml.Attribute, error) {
+	parsed, err := config.ParseAttributeDeclaration(name, decl)
+	if err != nil {
+		return saml.Attribute{}, err
+	}
+
+	attr := saml.Attribute{
+		FriendlyName: parsed.FriendlyName,
+		Name:         parsed.Name,
+		NameFormat:   parsed.NameFormat,
+		Values:       make([]saml.AttributeValue, 0, len(parsed.Values)),
+	}
+	for _, v := range parsed.Values {
+		if v == nil {
+			attr.Values = append(attr.Values, saml.AttributeValue{Type: xsiNilType})
+			continue
+		}
+		attr.Values = append(attr.Values, saml.AttributeValue{Type: parsed.Type, Value: *v})
+	}
+	return attr, nil
+}
+
+// firstAttributeValue returns the first value a user's attribute is sent
+// with, read as buildCustomAttributes reads it, or false if the attribute
+// is missing, invalid or has no non-nil value.
+func firstAttributeValue(user *config.User, name string) (string, bool) {
+	value, ok := user.Attributes[name]
+	if !ok {
+		return "", false
+	}
+	values := attributeValues(value)
+	if decl, ok := value.(map[string]interface{}); ok {
+		attr, err := declaredAttribute(name, decl)
+		if err != nil {
+			return "", false
+		}
+		values = attr.Values
+	}
+	for _, v := range values {
+		if v.Type != xsiNilType {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
 // attributeValues converts a value to SAML attribute values.
 func attributeValues(value interface{}) []saml.AttributeValue {
+	// Strings are always single-valued (cast would split them on whitespace)
+	if str, ok := value.(string); ok {
+		return []saml.AttributeValue{{Type: "xs:string", Value: str}}
+	}
+
 	// Handle slices specially to create multiple attribute values
 	if slice, err := cast.ToStringSliceE(value); err == nil && len(slice) > 0 {
 		values := make([]saml.AttributeValue, 0, len(slice))
@@ -75,20 +185,100 @@ func attributeValues(value interface{}) []saml.AttributeValue {
 	return []saml.AttributeValue{{Type: "xs:string", Value: cast.ToString(value)}}
 }
 
-// StorePendingRequest stores a pending SAML auth request.
+// StorePendingRequest stores a pending SAML auth request. If the provider
+// is full, expired requests are removed and then the oldest are evicted.
 func (sp *SessionProvider) StorePendingRequest(requestID string, req *saml.IdpAuthnRequest, spConfig *config.ServiceProvider) {
 	sp.mu.Lock()
 	defer sp.mu.Unlock()
 
+	now := time.Now()
+	if _, exists := sp.pendingRequests[requestID]; !exists && len(sp.pendingRequests) >= sp.maxPending {
+		sp.removeExpired(now)
+		for len(sp.pendingRequests) >= sp.maxPending {
+			sp.evictOldest()
+		}
+	}
+
 	sp.pendingRequests[requestID] = &SessionData{
 		ID:          requestID,
 		SP:          spConfig,
-		CreateTime:  time.Now(),
-		ExpireTime:  time.Now().Add(10 * time.Minute),
+		CreateTime:  now,
+		ExpireTime:  now.Add(sp.ttl),
 		SAMLRequest: req,
 	}
 }
 
+// removeExpired deletes requests that expired before now, returning how
+// many were removed. The caller must hold the lock.
+func (sp *SessionProvider) removeExpired(now time.Time) int {
+	removed := 0
+	for id, session := range sp.pendingRequests {
+		if session.ExpireTime.Before(now) {
+			delete(sp.pendingRequests, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// evictOldest deletes the request created first. The caller must hold the
+// lock.
+func (sp *SessionProvider) evictOldest() {
+	var oldestID string
+	var oldest time.Time
+	for id, session := range sp.pendingRequests {
+		if oldestID == "" || session.CreateTime.Before(oldest) {
+			oldestID, oldest = id, session.CreateTime
+		}
+	}
+	delete(sp.pendingRequests, oldestID)
+	slog.Debug("Evicted oldest pending request", "pending_id", oldestID)
+}
+
+// SweepExpired removes expired pending requests, returning how many were
+// removed.
+func (sp *SessionProvider) SweepExpired() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.removeExpired(time.Now())
+}
+
+// StartSweeper removes expired pending requests every interval in the
+// background until Stop is called. A zero interval uses the default.
+func (sp *SessionProvider) StartSweeper(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultPendingSweepInterval
+	}
+	sp.stopSweeper = make(chan struct{})
+	sp.sweeperDone = make(chan struct{})
+
+	go func() {
+		defer close(sp.sweeperDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if removed := sp.SweepExpired(); removed > 0 {
+					slog.Debug("Removed expired pending requests", "count", removed)
+				}
+			case <-sp.stopSweeper:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the sweeper, if it was started, and waits for it to exit.
+func (sp *SessionProvider) Stop() {
+	if sp.stopSweeper == nil {
+		return
+	}
+	close(sp.stopSweeper)
+	<-sp.sweeperDone
+	sp.stopSweeper = nil
+}
+
 // GetPendingRequest retrieves a pending SAML auth request.
 func (sp *SessionProvider) GetPendingRequest(requestID string) (*SessionData, bool) {
 	sp.mu.RLock()
@@ -104,26 +294,16 @@ func (sp *SessionProvider) GetPendingRequest(requestID string) (*SessionData, bo
 	return session, true
 }
 
+// PendingCount returns the number of stored pending requests.
+func (sp *SessionProvider) PendingCount() int {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+	return len(sp.pendingRequests)
+}
+
 // DeletePendingRequest removes a pending request.
 func (sp *SessionProvider) DeletePendingRequest(requestID string) {
 	sp.mu.Lock()
 	defer sp.mu.Unlock()
 	delete(sp.pendingRequests, requestID)
 }
-
-func randomHex(n int) string {
-	const hexChars = "0123456789abcdef"
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = hexChars[pseudoRand()%16]
-	}
-	return string(b)
-}
-
-// Simple pseudo-random for session IDs (not cryptographic)
-var randState uint64 = uint64(time.Now().UnixNano())
-
-func pseudoRand() int {
-	randState = randState*6364136223846793005 + 1
-	return int(randState >> 33)
-}
@@ -2,6 +2,7 @@ package idp
 
 import (
 	"testing"
+	"time"
 
 	"github.com/breakroom/saml-test-idp/internal/config"
 )
@@ -58,6 +59,8 @@ func TestAttributeValues(t *testing.T) {
 		expected int // number of values
 	}{
 		{"string", "test", 1},
+		{"string with spaces", "Alice Developer", 1},
+		{"empty string", "", 1},
 		{"string slice interface", []interface{}{"a", "b", "c"}, 3},
 		{"string slice", []string{"a", "b"}, 2},
 		{"bool true", true, 1},
@@ -75,7 +78,7 @@ func TestAttributeValues(t *testing.T) {
 }
 
 func TestSessionProviderPendingRequests(t *testing.T) {
-	sp := NewSessionProvider()
+	sp := NewSessionProvider(0, 0)
 
 	spConfig := &config.ServiceProvider{
 		EntityID: "https://sp.example.com",
@@ -99,28 +102,63 @@ func TestSessionProviderPendingRequests(t *testing.T) {
 	}
 }
 
-func TestRandomHex(t *testing.T) {
-	hex := randomHex(16)
-	if len(hex) != 16 {
-		t.Errorf("Expected length 16, got %d", len(hex))
+func TestSessionProviderSweepExpired(t *testing.T) {
+	sp := NewSessionProvider(time.Minute, 0)
+	spConfig := &config.ServiceProvider{EntityID: "https://sp.example.com"}
+
+	sp.StorePendingRequest("expired", nil, spConfig)
+	sp.StorePendingRequest("live", nil, spConfig)
+	sp.pendingRequests["expired"].ExpireTime = time.Now().Add(-time.Second)
+
+	if removed := sp.SweepExpired(); removed != 1 {
+		t.Errorf("Expected 1 request removed, got %d", removed)
 	}
+	if _, ok := sp.pendingRequests["live"]; !ok || sp.PendingCount() != 1 {
+		t.Error("Expected only the live request to remain")
+	}
+}
 
-	// Verify all characters are hex
-	for _, c := range hex {
-		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
-			t.Errorf("Invalid hex character: %c", c)
+func TestSessionProviderSweeper(t *testing.T) {
+	sp := NewSessionProvider(time.Millisecond, 0)
+	sp.StorePendingRequest("abandoned", nil, &config.ServiceProvider{})
+	sp.StartSweeper(5 * time.Millisecond)
+	defer sp.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for sp.PendingCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the sweeper to remove the expired request")
 		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSessionProviderMaxPending(t *testing.T) {
+	sp := NewSessionProvider(time.Minute, 2)
+	spConfig := &config.ServiceProvider{}
+
+	sp.StorePendingRequest("first", nil, spConfig)
+	sp.StorePendingRequest("second", nil, spConfig)
+	sp.pendingRequests["first"].CreateTime = time.Now().Add(-time.Second)
+	sp.StorePendingRequest("third", nil, spConfig)
+
+	if sp.PendingCount() != 2 {
+		t.Errorf("Expected 2 pending requests, got %d", sp.PendingCount())
+	}
+	if _, ok := sp.pendingRequests["first"]; ok {
+		t.Error("Expected the oldest request to be evicted")
 	}
 
-	// Test uniqueness
-	hex2 := randomHex(16)
-	if hex == hex2 {
-		t.Error("randomHex should generate unique values")
+	// Expired requests are removed before evicting live ones
+	sp.pendingRequests["second"].ExpireTime = time.Now().Add(-time.Second)
+	sp.StorePendingRequest("fourth", nil, spConfig)
+	if _, ok := sp.pendingRequests["third"]; !ok {
+		t.Error("Expected the live request to be kept when an expired one can be removed")
 	}
 }
 
 func TestGetSessionAlwaysReturnsNil(t *testing.T) {
-	sp := NewSessionProvider()
+	sp := NewSessionProvider(0, 0)
 
 	// GetSession should always return nil (no persistent sessions in test IDP)
 	session := sp.GetSession(nil, nil, nil)
@@ -128,3 +166,47 @@ func TestGetSessionAlwaysReturnsNil(t *testing.T) {
 		t.Error("GetSession should always return nil for test IDP")
 	}
 }
+
+func TestBuildCustomAttributesDeclared(t *testing.T) {
+	user := &config.User{
+		Name: "Test User",
+		Attributes: map[string]interface{}{
+			"urn:oid:2.16.840.1.113730.3.1.3": map[string]interface{}{
+				"name_format":   "uri",
+				"friendly_name": "employeeNumber",
+				"type":          "xs:integer",
+				"value":         42,
+			},
+			"nickname": map[string]interface{}{
+				"type":   "none",
+				"values": []interface{}{"Al", nil},
+			},
+			"broken": map[string]interface{}{
+				"type": "bogus",
+			},
+		},
+	}
+
+	attrs := buildCustomAttributes(user)
+	if len(attrs) != 2 {
+		t.Fatalf("Expected 2 attributes (invalid one skipped), got %d", len(attrs))
+	}
+
+	for _, attr := range attrs {
+		switch attr.Name {
+		case "urn:oid:2.16.840.1.113730.3.1.3":
+			if attr.FriendlyName != "employeeNumber" || attr.NameFormat != config.AttrNameFormatURI {
+				t.Errorf("Unexpected attribute: %+v", attr)
+			}
+			if attr.Values[0].Type != "xs:integer" || attr.Values[0].Value != "42" {
+				t.Errorf("Expected xs:integer 42, got %+v", attr.Values[0])
+			}
+		case "nickname":
+			if attr.Values[0].Type != "" || attr.Values[1].Type != xsiNilType {
+				t.Errorf("Expected untyped and nil values, got %+v", attr.Values)
+			}
+		default:
+			t.Errorf("Unexpected attribute %s", attr.Name)
+		}
+	}
+}
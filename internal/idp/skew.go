@@ -0,0 +1,83 @@
+package idp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// assertionTiming overrides the validity period the saml library gives
+// assertions, to simulate a skewed IdP clock or test an SP's tolerance.
+type assertionTiming struct {
+	// validity is how long the assertion is valid after it's issued.
+	validity time.Duration
+	// sessionLifetime, if set, adds a SessionNotOnOrAfter this long after
+	// the AuthnInstant.
+	sessionLifetime time.Duration
+}
+
+// newAssertionTiming returns the timing for an SP's assertions, or nil to
+// keep the library's defaults. offset is the login's clock offset.
+func newAssertionTiming(sp *config.ServiceProvider, offset time.Duration) *assertionTiming {
+	if offset == 0 && sp.AssertionValidity == 0 && sp.SessionLifetime == 0 {
+		return nil
+	}
+	timing := &assertionTiming{
+		validity:        sp.AssertionValidity,
+		sessionLifetime: sp.SessionLifetime,
+	}
+	if timing.validity == 0 {
+		timing.validity = saml.MaxIssueDelay
+	}
+	return timing
+}
+
+// apply sets the validity period of an assertion issued at now. Unlike the
+// library, NotBefore isn't moved to the AuthnRequest's IssueInstant, which
+// would hide the offset.
+func (t *assertionTiming) apply(assertion *saml.Assertion, now time.Time) {
+	if assertion.Conditions != nil {
+		assertion.Conditions.NotBefore = now.Add(-saml.MaxClockSkew)
+		assertion.Conditions.NotOnOrAfter = now.Add(t.validity)
+	}
+	if assertion.Subject != nil {
+		for i := range assertion.Subject.SubjectConfirmations {
+			if data := assertion.Subject.SubjectConfirmations[i].SubjectConfirmationData; data != nil {
+				data.NotOnOrAfter = now.Add(t.validity)
+			}
+		}
+	}
+	if t.sessionLifetime > 0 {
+		for i := range assertion.AuthnStatements {
+			notOnOrAfter := assertion.AuthnStatements[i].AuthnInstant.Add(t.sessionLifetime)
+			assertion.AuthnStatements[i].SessionNotOnOrAfter = &notOnOrAfter
+		}
+	}
+}
+
+// clockOffset returns the offset added to the server's clock in messages
+// for a request: the login's override, else the SP's clock_offset.
+func (s *Server) clockOffset(req *saml.IdpAuthnRequest, pendingSession *SessionData) time.Duration {
+	if pendingSession != nil && pendingSession.ClockOffset != nil {
+		return *pendingSession.ClockOffset
+	}
+	if sp := s.spProvider.GetServiceProviderConfig(requestSP(req)); sp != nil {
+		return sp.ClockOffset
+	}
+	return 0
+}
+
+// parseClockOffset parses the clock offset entered on the login page. An
+// empty value keeps the SP's offset.
+func parseClockOffset(value string) (*time.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+	offset, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clock offset %q (use e.g. -5m or 90s)", value)
+	}
+	return &offset, nil
+}
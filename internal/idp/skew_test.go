@@ -0,0 +1,146 @@
+package idp
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// skewedLogin logs in with the server's clock fixed at now and returns the
+// parsed response.
+func skewedLogin(t *testing.T, server *Server, now time.Time, form url.Values) *saml.Response {
+	t.Helper()
+
+	server.SetClock(FixedClock(now))
+	requestID := startSSO(t, server)
+	form.Set("user", "Test User")
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, form)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response saml.Response
+	if err := xml.Unmarshal([]byte(decodeSAMLResponse(t, w.Body.String())), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Assertion == nil {
+		t.Fatal("Expected an unencrypted assertion")
+	}
+	return &response
+}
+
+func TestClockOffset(t *testing.T) {
+	server := testServer(t)
+	server.config.ServiceProviders[0].ClockOffset = -10 * time.Minute
+	now := time.Now().UTC().Truncate(time.Second)
+
+	response := skewedLogin(t, server, now, url.Values{})
+	skewed := now.Add(-10 * time.Minute)
+	assertion := response.Assertion
+
+	if !response.IssueInstant.Equal(skewed) || !assertion.IssueInstant.Equal(skewed) {
+		t.Errorf("Expected IssueInstant %v, got response %v and assertion %v", skewed, response.IssueInstant, assertion.IssueInstant)
+	}
+	if !assertion.Conditions.NotBefore.Equal(skewed.Add(-saml.MaxClockSkew)) {
+		t.Errorf("Expected NotBefore %v, got %v", skewed.Add(-saml.MaxClockSkew), assertion.Conditions.NotBefore)
+	}
+	if !assertion.Conditions.NotOnOrAfter.Equal(skewed.Add(saml.MaxIssueDelay)) {
+		t.Errorf("Expected NotOnOrAfter %v, got %v", skewed.Add(saml.MaxIssueDelay), assertion.Conditions.NotOnOrAfter)
+	}
+	if !assertion.AuthnStatements[0].AuthnInstant.Equal(skewed) {
+		t.Errorf("Expected AuthnInstant %v, got %v", skewed, assertion.AuthnStatements[0].AuthnInstant)
+	}
+	if assertion.AuthnStatements[0].SessionNotOnOrAfter != nil {
+		t.Error("Expected no SessionNotOnOrAfter without session_lifetime")
+	}
+}
+
+func TestClockOffsetAttributeTemplates(t *testing.T) {
+	server := testServer(t)
+	server.config.ServiceProviders[0].ClockOffset = -10 * time.Minute
+	server.config.ServiceProviders[0].Users[0].Attributes = map[string]interface{}{
+		"loginTime": "{{ now | iso8601 }}",
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	response := skewedLogin(t, server, now, url.Values{})
+	want := now.Add(-10 * time.Minute).Format(time.RFC3339)
+	var got string
+	for _, stmt := range response.Assertion.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			if attr.Name == "loginTime" {
+				got = attr.Values[0].Value
+			}
+		}
+	}
+	if got != want {
+		t.Errorf("Expected templated time %s, got %q", want, got)
+	}
+}
+
+func TestClockOffsetLoginOverride(t *testing.T) {
+	server := testServer(t)
+	server.config.ServiceProviders[0].ClockOffset = -10 * time.Minute
+	now := time.Now().UTC().Truncate(time.Second)
+
+	response := skewedLogin(t, server, now, url.Values{"clock_offset": {"2m"}})
+	if want := now.Add(2 * time.Minute); !response.Assertion.IssueInstant.Equal(want) {
+		t.Errorf("Expected the login's offset to win, got %v want %v", response.Assertion.IssueInstant, want)
+	}
+
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}, "clock_offset": {"soon"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid clock offset") {
+		t.Errorf("Expected 400 for an invalid offset, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAssertionValidityAndSessionLifetime(t *testing.T) {
+	server := testServer(t)
+	server.config.ServiceProviders[0].AssertionValidity = 10 * time.Second
+	server.config.ServiceProviders[0].SessionLifetime = 8 * time.Hour
+	now := time.Now().UTC().Truncate(time.Second)
+
+	assertion := skewedLogin(t, server, now, url.Values{}).Assertion
+	if !assertion.Conditions.NotOnOrAfter.Equal(now.Add(10 * time.Second)) {
+		t.Errorf("Expected a 10s validity window, got NotOnOrAfter %v", assertion.Conditions.NotOnOrAfter)
+	}
+	data := assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData
+	if !data.NotOnOrAfter.Equal(now.Add(10 * time.Second)) {
+		t.Errorf("Expected SubjectConfirmationData NotOnOrAfter %v, got %v", now.Add(10*time.Second), data.NotOnOrAfter)
+	}
+	if sessionEnd := assertion.AuthnStatements[0].SessionNotOnOrAfter; sessionEnd == nil || !sessionEnd.Equal(now.Add(8*time.Hour)) {
+		t.Errorf("Expected SessionNotOnOrAfter %v, got %v", now.Add(8*time.Hour), sessionEnd)
+	}
+}
+
+func TestClockOffsetStatusResponse(t *testing.T) {
+	server := testServer(t)
+	server.config.ServiceProviders[0].ClockOffset = time.Hour
+	now := time.Now().UTC().Truncate(time.Second)
+	server.SetClock(FixedClock(now))
+
+	w := postAuthnRequest(server, `<samlp:NameIDPolicy Format="urn:example:unsupported"/>`)
+	var response saml.Response
+	if err := xml.Unmarshal([]byte(decodeSAMLResponse(t, w.Body.String())), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if !response.IssueInstant.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected the SP's offset in status responses, got %v", response.IssueInstant)
+	}
+}
+
+func TestNewAssertionTimingDefaults(t *testing.T) {
+	if timing := newAssertionTiming(&config.ServiceProvider{}, 0); timing != nil {
+		t.Errorf("Expected library defaults without clock settings, got %+v", timing)
+	}
+	if timing := newAssertionTiming(&config.ServiceProvider{}, time.Minute); timing == nil || timing.validity != saml.MaxIssueDelay {
+		t.Errorf("Expected the default validity with an offset, got %+v", timing)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/breakroom/saml-test-idp/internal/config"
@@ -21,6 +23,11 @@ type ServiceProviderProvider struct {
 type ServiceProviderEntry struct {
 	Metadata *saml.EntityDescriptor
 	Config   *config.ServiceProvider
+	// SubjectIDReq is the subject-id:req entity attribute from the metadata.
+	SubjectIDReq string
+
+	// release is the SP's compiled attribute release policy
+	release *releasePolicy
 }
 
 // NewServiceProviderProvider creates a new SP provider from config.
@@ -31,6 +38,9 @@ func NewServiceProviderProvider(sps []config.ServiceProvider) (*ServiceProviderP
 
 	for i := range sps {
 		sp := &sps[i]
+		if _, ok := provider.sps[sp.EntityID]; ok {
+			return nil, fmt.Errorf("duplicate SP entity ID %s", sp.EntityID)
+		}
 		entry, err := provider.createEntry(sp)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create SP entry for %s: %w", sp.EntityID, err)
@@ -42,18 +52,22 @@ func NewServiceProviderProvider(sps []config.ServiceProvider) (*ServiceProviderP
 }
 
 func (p *ServiceProviderProvider) createEntry(sp *config.ServiceProvider) (*ServiceProviderEntry, error) {
+	if errs := checkServiceProvider(sp); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	release, err := compileReleasePolicy(sp.AttributeRelease)
+	if err != nil {
+		return nil, err
+	}
+
 	var metadata *saml.EntityDescriptor
+	var subjectIDReq string
 
 	if sp.MetadataFile != "" {
-		// Load metadata from file (path is resolved relative to config file)
-		metadataPath := sp.GetMetadataFilePath()
-		data, err := os.ReadFile(metadataPath)
+		metadata, subjectIDReq, err = loadMetadata(sp)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read metadata file: %w", err)
-		}
-		metadata = &saml.EntityDescriptor{}
-		if err := xml.Unmarshal(data, metadata); err != nil {
-			return nil, fmt.Errorf("failed to parse metadata: %w", err)
+			return nil, &settingError{path: "metadata_file", err: err}
 		}
 	} else if sp.ACSURL != "" {
 		// Create metadata from ACS URL
@@ -76,11 +90,80 @@ func (p *ServiceProviderProvider) createEntry(sp *config.ServiceProvider) (*Serv
 	}
 
 	return &ServiceProviderEntry{
-		Metadata: metadata,
-		Config:   sp,
+		Metadata:     metadata,
+		Config:       sp,
+		SubjectIDReq: subjectIDReq,
+		release:      release,
 	}, nil
 }
 
+// settingError is a problem with one SP setting, named by its path within
+// the SP, such as users[1].name_id_format.
+type settingError struct {
+	path string
+	err  error
+}
+
+func (e *settingError) Error() string { return e.path + ": " + e.err.Error() }
+
+func (e *settingError) Unwrap() error { return e.err }
+
+// checkServiceProvider checks the SP and user settings the IdP interprets,
+// returning every problem found.
+func checkServiceProvider(sp *config.ServiceProvider) []*settingError {
+	var errs []*settingError
+	add := func(path string, format string, args ...interface{}) {
+		errs = append(errs, &settingError{path: path, err: fmt.Errorf(format, args...)})
+	}
+
+	if _, ok := AttributeProfiles[sp.AttributeProfile]; sp.AttributeProfile != "" && !ok {
+		add("attribute_profile", "unknown profile %q (use one of %s)", sp.AttributeProfile, strings.Join(AttributeProfileNames(), ", "))
+	}
+	if sp.AssertionValidity < 0 {
+		add("assertion_validity", "must not be negative")
+	}
+	if sp.SessionLifetime < 0 {
+		add("session_lifetime", "must not be negative")
+	}
+	if _, ok := NameIDFormats[sp.NameIDFormat]; sp.NameIDFormat != "" && !ok {
+		add("name_id_format", "unknown format %q (use one of %s)", sp.NameIDFormat, strings.Join(nameIDFormatNames(), ", "))
+	}
+	errs = append(errs, checkSubjectIDConfig(sp)...)
+
+	for i := range sp.Users {
+		user := &sp.Users[i]
+		if _, ok := NameIDFormats[user.NameIDFormat]; user.NameIDFormat != "" && !ok {
+			add(fmt.Sprintf("users[%d].name_id_format", i), "unknown format %q (use one of %s)", user.NameIDFormat, strings.Join(nameIDFormatNames(), ", "))
+		}
+		var formats []string
+		for format := range user.NameIDs {
+			if _, ok := NameIDFormats[format]; !ok {
+				formats = append(formats, format)
+			}
+		}
+		sort.Strings(formats)
+		for _, format := range formats {
+			add(fmt.Sprintf("users[%d].name_ids.%s", i, format), "unknown format %q", format)
+		}
+	}
+	return append(errs, checkAttributeTemplates(sp)...)
+}
+
+// loadMetadata reads and parses an SP's metadata file, returning the
+// metadata and its subject-id:req entity attribute.
+func loadMetadata(sp *config.ServiceProvider) (*saml.EntityDescriptor, string, error) {
+	// The path is resolved relative to the config file
+	data, err := os.ReadFile(sp.GetMetadataFilePath())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read metadata file: %w", err)
+	}
+	metadata := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(data, metadata); err != nil {
+		return nil, "", fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	return metadata, subjectIDReqFromMetadata(data), nil
+}
+
 // GetServiceProvider implements saml.ServiceProviderProvider.
 func (p *ServiceProviderProvider) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
 	p.mu.RLock()
@@ -105,6 +188,36 @@ func (p *ServiceProviderProvider) GetServiceProviderConfig(entityID string) *con
 	return entry.Config
 }
 
+// SubjectIDReq returns the subject identifier an SP requires: its
+// subject_id_req setting, else the subject-id:req entity attribute from its
+// metadata, else "".
+func (p *ServiceProviderProvider) SubjectIDReq(entityID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entry, ok := p.sps[entityID]
+	if !ok {
+		return ""
+	}
+	if entry.Config.SubjectIDReq != "" {
+		return entry.Config.SubjectIDReq
+	}
+	return entry.SubjectIDReq
+}
+
+// releasePolicy returns an SP's compiled attribute release policy applied
+// to a request, or nil if it has none.
+func (p *ServiceProviderProvider) releasePolicy(entityID string, req *saml.IdpAuthnRequest) *releasePolicy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entry, ok := p.sps[entityID]
+	if !ok {
+		return nil
+	}
+	return entry.release.forRequest(req)
+}
+
 // GetAllServiceProviders returns all configured SPs.
 func (p *ServiceProviderProvider) GetAllServiceProviders() []*config.ServiceProvider {
 	p.mu.RLock()
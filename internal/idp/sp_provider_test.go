@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/breakroom/saml-test-idp/internal/config"
 )
@@ -126,6 +127,20 @@ func TestNewServiceProviderProviderInvalidConfig(t *testing.T) {
 	}
 }
 
+func TestNewServiceProviderProviderNegativeValidity(t *testing.T) {
+	sps := []config.ServiceProvider{
+		{
+			EntityID:          "https://sp.example.com",
+			ACSURL:            "https://sp.example.com/acs",
+			AssertionValidity: -time.Minute,
+		},
+	}
+
+	if _, err := NewServiceProviderProvider(sps); err == nil {
+		t.Error("Expected error for a negative assertion_validity")
+	}
+}
+
 func TestGetAllServiceProviders(t *testing.T) {
 	sps := []config.ServiceProvider{
 		{EntityID: "https://sp1.example.com", ACSURL: "https://sp1.example.com/acs"},
@@ -143,3 +158,24 @@ func TestGetAllServiceProviders(t *testing.T) {
 		t.Errorf("Expected 3 SPs, got %d", len(allSPs))
 	}
 }
+
+func TestNewServiceProviderProviderDuplicateEntityID(t *testing.T) {
+	sps := []config.ServiceProvider{
+		{EntityID: "https://sp.example.com", ACSURL: "https://sp.example.com/acs"},
+		{EntityID: "https://sp.example.com", ACSURL: "https://sp.example.com/other"},
+	}
+
+	if _, err := NewServiceProviderProvider(sps); err == nil {
+		t.Error("Expected error for a duplicate entity ID")
+	}
+}
+
+func TestNewServiceProviderProviderUnknownNameIDFormat(t *testing.T) {
+	sps := []config.ServiceProvider{
+		{EntityID: "https://sp.example.com", ACSURL: "https://sp.example.com/acs", NameIDFormat: "emial"},
+	}
+
+	if _, err := NewServiceProviderProvider(sps); err == nil {
+		t.Error("Expected error for an unknown name_id_format")
+	}
+}
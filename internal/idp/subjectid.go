@@ -0,0 +1,140 @@
+package idp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// Attribute names from the SAML V2.0 Subject Identifier Attributes Profile.
+const (
+	SubjectIDAttributeName  = "urn:oasis:names:tc:SAML:attribute:subject-id"
+	PairwiseIDAttributeName = "urn:oasis:names:tc:SAML:attribute:pairwise-id"
+
+	// subjectIDReqAttributeName is the SP entity attribute stating which
+	// subject identifier the SP requires.
+	subjectIDReqAttributeName = "urn:oasis:names:tc:SAML:profiles:subject-id:req"
+)
+
+// subject-id:req values.
+const (
+	SubjectIDReqSubjectID  = "subject-id"
+	SubjectIDReqPairwiseID = "pairwise-id"
+	SubjectIDReqAny        = "any"
+	SubjectIDReqNone       = "none"
+)
+
+var subjectIDReqValues = []string{SubjectIDReqSubjectID, SubjectIDReqPairwiseID, SubjectIDReqAny, SubjectIDReqNone}
+
+var (
+	// subjectIDUniqueRe matches the unique ID part of a subject identifier.
+	subjectIDUniqueRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9=-]{0,126}$`)
+	// subjectIDScopeRe matches the scope (domain) part of a subject identifier.
+	subjectIDScopeRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.-]{0,126}$`)
+)
+
+// isSubjectIDReq reports whether s is a valid subject-id:req value.
+func isSubjectIDReq(s string) bool {
+	for _, v := range subjectIDReqValues {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSubjectIDConfig checks an SP's subject identifier settings.
+func checkSubjectIDConfig(sp *config.ServiceProvider) []*settingError {
+	var errs []*settingError
+	if sp.SubjectIDReq != "" && !isSubjectIDReq(sp.SubjectIDReq) {
+		errs = append(errs, &settingError{path: "subject_id_req", err: fmt.Errorf("unknown value %q (use one of %s)", sp.SubjectIDReq, strings.Join(subjectIDReqValues, ", "))})
+	}
+	if sp.SubjectIDScope != "" && !subjectIDScopeRe.MatchString(sp.SubjectIDScope) {
+		errs = append(errs, &settingError{path: "subject_id_scope", err: fmt.Errorf("invalid scope %q", sp.SubjectIDScope)})
+	}
+	for i := range sp.Users {
+		if id := sp.Users[i].SubjectID; id != "" && !subjectIDUniqueRe.MatchString(id) {
+			errs = append(errs, &settingError{path: fmt.Sprintf("users[%d].subject_id", i), err: fmt.Errorf("invalid value %q (use letters, digits, = and -)", id)})
+		}
+	}
+	return errs
+}
+
+// subjectIDReqFromMetadata returns the subject-id:req entity attribute from
+// SP metadata, or "" if the metadata doesn't declare one.
+func subjectIDReqFromMetadata(data []byte) string {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return ""
+	}
+	for _, attrEl := range doc.FindElements("//EntityAttributes/Attribute") {
+		if attrEl.SelectAttrValue("Name", "") != subjectIDReqAttributeName {
+			continue
+		}
+		if valueEl := attrEl.FindElement("AttributeValue"); valueEl != nil {
+			return strings.TrimSpace(valueEl.Text())
+		}
+	}
+	return ""
+}
+
+// subjectIDScope returns the scope for an SP's subject identifiers: the
+// configured subject_id_scope or the host name of the IdP's base URL.
+func (s *Server) subjectIDScope(sp *config.ServiceProvider) string {
+	if sp.SubjectIDScope != "" {
+		return strings.ToLower(sp.SubjectIDScope)
+	}
+	if u, err := url.Parse(s.config.Server.BaseURL); err == nil && u.Hostname() != "" {
+		return strings.ToLower(u.Hostname())
+	}
+	return "localhost"
+}
+
+// subjectIdentifierAttributes returns the subject-id and/or pairwise-id
+// attributes required by req. subject-id uses the user's subject_id or an
+// opaque value derived from their name_id; pairwise-id is additionally
+// derived from the SP entity ID, so each SP sees a different value.
+func (s *Server) subjectIdentifierAttributes(user *config.User, sp *config.ServiceProvider, req string) []saml.Attribute {
+	scope := s.subjectIDScope(sp)
+
+	var attrs []saml.Attribute
+	if req == SubjectIDReqSubjectID || req == SubjectIDReqAny {
+		unique := user.SubjectID
+		if unique == "" {
+			unique = opaqueID(s.persistentIDSalt, SubjectIDReqSubjectID, user.NameID)
+		}
+		attrs = append(attrs, subjectIdentifierAttribute(SubjectIDAttributeName, unique+"@"+scope))
+	}
+	if req == SubjectIDReqPairwiseID || req == SubjectIDReqAny {
+		unique := opaqueID(s.persistentIDSalt, SubjectIDReqPairwiseID, user.NameID, sp.EntityID)
+		attrs = append(attrs, subjectIdentifierAttribute(PairwiseIDAttributeName, unique+"@"+scope))
+	}
+	return attrs
+}
+
+func subjectIdentifierAttribute(name, value string) saml.Attribute {
+	return saml.Attribute{
+		Name:       name,
+		NameFormat: config.AttrNameFormatURI,
+		Values:     []saml.AttributeValue{{Type: "xs:string", Value: value}},
+	}
+}
+
+// opaqueID derives an identifier valid as the unique ID part of a subject
+// identifier from the given parts, keyed with the IdP's salt.
+func opaqueID(salt []byte, parts ...string) string {
+	mac := hmac.New(sha256.New, salt)
+	for _, p := range parts {
+		mac.Write([]byte(p))
+		mac.Write([]byte{0})
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(mac.Sum(nil))
+}
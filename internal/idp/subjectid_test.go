@@ -0,0 +1,180 @@
+package idp
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+)
+
+func TestSubjectIdentifierAttributes(t *testing.T) {
+	server := testServer(t)
+	user := &config.User{Name: "Alice", NameID: "alice@example.com"}
+	sp1 := &config.ServiceProvider{EntityID: "https://sp1.example.com", SubjectIDScope: "Example.COM"}
+	sp2 := &config.ServiceProvider{EntityID: "https://sp2.example.com", SubjectIDScope: "example.com"}
+
+	values := func(sp *config.ServiceProvider, req string) map[string]string {
+		out := map[string]string{}
+		for _, attr := range server.subjectIdentifierAttributes(user, sp, req) {
+			if attr.NameFormat != config.AttrNameFormatURI {
+				t.Errorf("Expected uri NameFormat for %s, got %q", attr.Name, attr.NameFormat)
+			}
+			out[attr.Name] = attr.Values[0].Value
+		}
+		return out
+	}
+
+	any1 := values(sp1, SubjectIDReqAny)
+	if len(any1) != 2 {
+		t.Fatalf("Expected subject-id and pairwise-id for any, got %v", any1)
+	}
+	for name, value := range any1 {
+		unique, scope, ok := strings.Cut(value, "@")
+		if !ok || scope != "example.com" || !subjectIDUniqueRe.MatchString(unique) {
+			t.Errorf("Invalid %s value %q", name, value)
+		}
+	}
+
+	any2 := values(sp2, SubjectIDReqAny)
+	if any1[SubjectIDAttributeName] != any2[SubjectIDAttributeName] {
+		t.Error("Expected subject-id to be the same for every SP")
+	}
+	if any1[PairwiseIDAttributeName] == any2[PairwiseIDAttributeName] {
+		t.Error("Expected pairwise-id to differ between SPs")
+	}
+
+	if got := values(sp1, SubjectIDReqPairwiseID); len(got) != 1 || got[PairwiseIDAttributeName] == "" {
+		t.Errorf("Expected only pairwise-id, got %v", got)
+	}
+	if got := values(sp1, SubjectIDReqNone); len(got) != 0 {
+		t.Errorf("Expected no attributes for none, got %v", got)
+	}
+
+	user.SubjectID = "alice-1234"
+	if got := values(sp1, SubjectIDReqSubjectID)[SubjectIDAttributeName]; got != "alice-1234@example.com" {
+		t.Errorf("Expected configured subject_id, got %q", got)
+	}
+
+	// Without a configured scope the IdP's host name is used
+	if got := server.subjectIDScope(&config.ServiceProvider{}); got != "localhost" {
+		t.Errorf("Expected default scope localhost, got %q", got)
+	}
+}
+
+func TestCheckSubjectIDConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		sp   config.ServiceProvider
+		want []string
+	}{
+		{"empty", config.ServiceProvider{}, nil},
+		{"valid", config.ServiceProvider{SubjectIDReq: "pairwise-id", SubjectIDScope: "example.com"}, nil},
+		{"unknown req", config.ServiceProvider{SubjectIDReq: "eppn"}, []string{"subject_id_req"}},
+		{"invalid scope", config.ServiceProvider{SubjectIDScope: "@example.com"}, []string{"subject_id_scope"}},
+		{"invalid subject_id", config.ServiceProvider{Users: []config.User{{Name: "Alice", SubjectID: "alice@example.com"}}}, []string{"users[0].subject_id"}},
+		{"all invalid", config.ServiceProvider{SubjectIDReq: "eppn", SubjectIDScope: "@example.com", Users: []config.User{{Name: "Alice", SubjectID: "alice@example.com"}}}, []string{"subject_id_req", "subject_id_scope", "users[0].subject_id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, err := range checkSubjectIDConfig(&tt.sp) {
+				got = append(got, err.path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("checkSubjectIDConfig() paths = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubjectIDReqFromMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	metadataPath := filepath.Join(tmpDir, "sp-metadata.xml")
+	metadataXML := `<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" xmlns:mdattr="urn:oasis:names:tc:SAML:metadata:attribute" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" entityID="https://metadata-sp.example.com">
+  <md:Extensions>
+    <mdattr:EntityAttributes>
+      <saml:Attribute Name="urn:oasis:names:tc:SAML:profiles:subject-id:req" NameFormat="urn:oasis:names:tc:SAML:2.0:attrname-format:uri">
+        <saml:AttributeValue>pairwise-id</saml:AttributeValue>
+      </saml:Attribute>
+    </mdattr:EntityAttributes>
+  </md:Extensions>
+  <md:SPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://metadata-sp.example.com/acs" index="1"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>`
+	if err := os.WriteFile(metadataPath, []byte(metadataXML), 0644); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+
+	provider, err := NewServiceProviderProvider([]config.ServiceProvider{
+		{EntityID: "https://metadata-sp.example.com", MetadataFile: metadataPath},
+		{EntityID: "https://acs-sp.example.com", ACSURL: "https://acs-sp.example.com/acs"},
+	})
+	if err != nil {
+		t.Fatalf("NewServiceProviderProvider failed: %v", err)
+	}
+
+	if got := provider.SubjectIDReq("https://metadata-sp.example.com"); got != SubjectIDReqPairwiseID {
+		t.Errorf("Expected pairwise-id from metadata, got %q", got)
+	}
+	if got := provider.SubjectIDReq("https://acs-sp.example.com"); got != "" {
+		t.Errorf("Expected no requirement, got %q", got)
+	}
+
+	// The config setting overrides the metadata
+	provider.GetServiceProviderConfig("https://metadata-sp.example.com").SubjectIDReq = SubjectIDReqNone
+	if got := provider.SubjectIDReq("https://metadata-sp.example.com"); got != SubjectIDReqNone {
+		t.Errorf("Expected config to override metadata, got %q", got)
+	}
+}
+
+func TestLoginFlowSubjectIdentifiers(t *testing.T) {
+	server := testServer(t)
+
+	login := func() map[string]string {
+		requestID := startSSO(t, server)
+		w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+		assertion := verifyResponse(t, server, decodeSAMLResponse(t, w.Body.String()))
+
+		out := map[string]string{}
+		for _, stmt := range assertion.AttributeStatements {
+			for _, attr := range stmt.Attributes {
+				if len(attr.Values) > 0 {
+					out[attr.Name] = attr.Values[0].Value
+				}
+			}
+		}
+		return out
+	}
+
+	// Without a requirement the name_id is sent as subject-id
+	if got := login()[SubjectIDAttributeName]; got != "test@example.com" {
+		t.Errorf("Expected name_id as subject-id, got %q", got)
+	}
+
+	server.spProvider.GetServiceProviderConfig("https://sp.example.com").SubjectIDReq = SubjectIDReqPairwiseID
+	attrs := login()
+	if _, ok := attrs[SubjectIDAttributeName]; ok {
+		t.Error("Expected no subject-id when pairwise-id is required")
+	}
+	if !strings.HasSuffix(attrs[PairwiseIDAttributeName], "@localhost") {
+		t.Errorf("Expected scoped pairwise-id, got %q", attrs[PairwiseIDAttributeName])
+	}
+	if attrs[PairwiseIDAttributeName] != login()[PairwiseIDAttributeName] {
+		t.Error("Expected pairwise-id to be stable across logins")
+	}
+
+	server.spProvider.GetServiceProviderConfig("https://sp.example.com").SubjectIDReq = SubjectIDReqNone
+	attrs = login()
+	if _, ok := attrs[SubjectIDAttributeName]; ok {
+		t.Error("Expected no subject-id for none")
+	}
+	if _, ok := attrs[PairwiseIDAttributeName]; ok {
+		t.Error("Expected no pairwise-id for none")
+	}
+}
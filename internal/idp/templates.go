@@ -0,0 +1,194 @@
+package idp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+)
+
+// AttributeTemplateData is the data available to templated attribute values.
+type AttributeTemplateData struct {
+	User       *config.User
+	SP         *config.ServiceProvider
+	RequestID  string
+	Now        time.Time
+	Attributes map[string]interface{}
+
+	// ids generates the values of uuid; crypto/rand if nil
+	ids IDSource
+}
+
+// attributeTemplateFuncs returns the functions available to templated
+// attribute values, with now bound to the given time and uuid to the given
+// ID source.
+func attributeTemplateFuncs(now time.Time, ids IDSource) template.FuncMap {
+	if ids == nil {
+		ids = CryptoIDSource()
+	}
+
+	return template.FuncMap{
+		"now":     func() time.Time { return now },
+		"iso8601": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+		"unix":    func(t time.Time) int64 { return t.Unix() },
+		"formatTime": func(layout string, t time.Time) string {
+			return t.UTC().Format(layout)
+		},
+		"uuid":  func() string { return newUUID(ids) },
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}
+}
+
+// isTemplate reports whether an attribute value needs template evaluation.
+func isTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+// renderAttributeTemplate evaluates a single templated value.
+func renderAttributeTemplate(text string, data *AttributeTemplateData) (string, error) {
+	tmpl, err := template.New("attribute").Funcs(attributeTemplateFuncs(data.Now, data.ids)).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// renderUserAttributes returns a copy of the user with every templated
+// attribute value evaluated, including values inside lists and attribute
+// declarations. Templates see the user's unevaluated attributes, and are
+// evaluated in attribute name order so seeded uuids are repeatable.
+func renderUserAttributes(user *config.User, data *AttributeTemplateData) (*config.User, error) {
+	if user == nil || user.Attributes == nil {
+		return user, nil
+	}
+
+	data.User = user
+	data.Attributes = user.Attributes
+
+	rendered := *user
+	rendered.Attributes = make(map[string]interface{}, len(user.Attributes))
+	names := make([]string, 0, len(user.Attributes))
+	for name := range user.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v, err := renderTemplateValue(user.Attributes[name], data)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		rendered.Attributes[name] = v
+	}
+	return &rendered, nil
+}
+
+// renderTemplateValue evaluates templates in a string, list or declaration.
+func renderTemplateValue(value interface{}, data *AttributeTemplateData) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !isTemplate(v) {
+			return v, nil
+		}
+		return renderAttributeTemplate(v, data)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			r, err := renderTemplateValue(v[i], data)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case []string:
+		out := make([]string, len(v))
+		for i := range v {
+			r, err := renderTemplateValue(v[i], data)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r.(string)
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			// Only values are templated, not the declaration settings
+			if key == "value" || key == "values" {
+				r, err := renderTemplateValue(item, data)
+				if err != nil {
+					return nil, err
+				}
+				item = r
+			}
+			out[key] = item
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+// checkAttributeTemplates parses every templated attribute value of an
+// SP's users so syntax errors are reported at startup.
+func checkAttributeTemplates(sp *config.ServiceProvider) []*settingError {
+	var errs []*settingError
+	funcs := attributeTemplateFuncs(time.Time{}, nil)
+	for i := range sp.Users {
+		user := &sp.Users[i]
+		names := make([]string, 0, len(user.Attributes))
+		for name := range user.Attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, text := range templateStrings(user.Attributes[name]) {
+				if _, err := template.New("attribute").Funcs(funcs).Parse(text); err != nil {
+					errs = append(errs, &settingError{path: fmt.Sprintf("users[%d].attributes.%s", i, name), err: err})
+					break
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// templateStrings collects the templated strings within an attribute value.
+func templateStrings(value interface{}) []string {
+	var out []string
+	switch v := value.(type) {
+	case string:
+		if isTemplate(v) {
+			out = append(out, v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			out = append(out, templateStrings(item)...)
+		}
+	case []string:
+		for _, item := range v {
+			out = append(out, templateStrings(item)...)
+		}
+	case map[string]interface{}:
+		out = append(out, templateStrings(v["value"])...)
+		out = append(out, templateStrings(v["values"])...)
+	}
+	return out
+}
+
+// newUUID returns a random (version 4) UUID read from ids.
+func newUUID(ids IDSource) string {
+	b, _ := hex.DecodeString(ids.HexID(32))
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
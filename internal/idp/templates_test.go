@@ -0,0 +1,122 @@
+package idp
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+)
+
+func TestRenderUserAttributes(t *testing.T) {
+	user := &config.User{
+		Name:   "Alice",
+		NameID: "alice@example.com",
+		Attributes: map[string]interface{}{
+			"mail":         "{{.User.NameID}}",
+			"sessionStart": "{{now | iso8601}}",
+			"audience":     "{{.SP.EntityID}}",
+			"request":      "{{.RequestID}}",
+			"greeting":     "Hello {{.Attributes.firstName | upper}}",
+			"firstName":    "Alice",
+			"ids":          []interface{}{"static", "{{unix .Now}}"},
+			"typed":        map[string]interface{}{"type": "xs:string", "value": "{{.User.Name}}"},
+		},
+	}
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	rendered, err := renderUserAttributes(user, &AttributeTemplateData{
+		SP:        &config.ServiceProvider{EntityID: "https://sp.example.com"},
+		RequestID: "id-123",
+		Now:       now,
+	})
+	if err != nil {
+		t.Fatalf("renderUserAttributes failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"mail":         "alice@example.com",
+		"sessionStart": "2024-01-02T03:04:05Z",
+		"audience":     "https://sp.example.com",
+		"request":      "id-123",
+		"greeting":     "Hello ALICE",
+		"firstName":    "Alice",
+	}
+	for name, want := range expected {
+		if got := rendered.Attributes[name]; got != want {
+			t.Errorf("Attribute %s = %v, want %v", name, got, want)
+		}
+	}
+
+	ids := rendered.Attributes["ids"].([]interface{})
+	if ids[0] != "static" || ids[1] != "1704164645" {
+		t.Errorf("Unexpected list rendering: %v", ids)
+	}
+
+	typed := rendered.Attributes["typed"].(map[string]interface{})
+	if typed["value"] != "Alice" || typed["type"] != "xs:string" {
+		t.Errorf("Unexpected declaration rendering: %v", typed)
+	}
+
+	// The original user is left untouched
+	if user.Attributes["mail"] != "{{.User.NameID}}" {
+		t.Error("Expected original attributes to be unchanged")
+	}
+}
+
+func TestRenderUserAttributesErrors(t *testing.T) {
+	user := &config.User{Attributes: map[string]interface{}{"x": "{{.Attributes.missing}}"}}
+	if _, err := renderUserAttributes(user, &AttributeTemplateData{}); err == nil {
+		t.Error("Expected error for missing attribute reference")
+	}
+}
+
+func TestCheckAttributeTemplates(t *testing.T) {
+	sp := &config.ServiceProvider{
+		Users: []config.User{{Name: "Bad", Attributes: map[string]interface{}{"x": "{{.User.Name"}}},
+	}
+	if errs := checkAttributeTemplates(sp); len(errs) != 1 || errs[0].path != "users[0].attributes.x" {
+		t.Errorf("Expected an error at users[0].attributes.x, got %v", errs)
+	}
+
+	sp.Users[0].Attributes["x"] = "{{uuid}}"
+	if errs := checkAttributeTemplates(sp); len(errs) != 0 {
+		t.Errorf("Expected valid template, got %v", errs)
+	}
+}
+
+func TestNewUUID(t *testing.T) {
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ids := CryptoIDSource()
+	a, b := newUUID(ids), newUUID(ids)
+	if !re.MatchString(a) {
+		t.Errorf("Invalid UUID: %s", a)
+	}
+	if a == b {
+		t.Error("Expected unique UUIDs")
+	}
+
+	if newUUID(SeededIDSource(1)) != newUUID(SeededIDSource(1)) {
+		t.Error("Expected the same UUID from the same seed")
+	}
+}
+
+func TestLoginFlowTemplatedAttributes(t *testing.T) {
+	server := testServer(t)
+	sp := server.spProvider.GetServiceProviderConfig("https://sp.example.com")
+	sp.Users[0].Attributes["loginId"] = "{{uuid}}"
+	sp.Users[0].Attributes["request"] = "{{.RequestID}}"
+
+	requestID := startSSO(t, server)
+	w := postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	response := decodeSAMLResponse(t, w.Body.String())
+
+	if strings.Contains(response, "{{") {
+		t.Error("Expected templates to be evaluated")
+	}
+	if !strings.Contains(response, ">id-test-request<") {
+		t.Error("Expected AuthnRequest ID in templated attribute")
+	}
+}
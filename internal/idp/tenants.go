@@ -0,0 +1,88 @@
+package idp
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+)
+
+// TenantPageData holds a tenant's entry on the landing page.
+type TenantPageData struct {
+	Name             string
+	MetadataURL      string
+	SSOURL           string
+	HistoryURL       string
+	ServiceProviders int
+}
+
+// NewTenants creates a server for every tenant in the configuration, or a
+// single server without tenants. When the tenants' base URLs have
+// different host names, their routes also match on the host name.
+func NewTenants(cfg *config.Config) ([]*Server, error) {
+	configs := cfg.TenantConfigs()
+
+	hosts := make(map[string]bool)
+	for _, tenantCfg := range configs {
+		if u, err := url.Parse(tenantCfg.Server.BaseURL); err == nil {
+			hosts[u.Hostname()] = true
+		}
+	}
+
+	servers := make([]*Server, 0, len(configs))
+	for i, tenantCfg := range configs {
+		server, err := New(tenantCfg)
+		if err != nil {
+			closeServers(servers)
+			if len(cfg.Tenants) > 0 {
+				return nil, fmt.Errorf("tenant %s: %w", cfg.Tenants[i].Name, err)
+			}
+			return nil, err
+		}
+		if len(cfg.Tenants) > 0 {
+			server.tenant = cfg.Tenants[i].Name
+			server.logger = server.logger.With("tenant", server.tenant)
+			if len(hosts) > 1 {
+				server.routeHost = server.idp.MetadataURL.Hostname()
+			}
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// Tenant returns the server's tenant name, or "" without tenants.
+func (s *Server) Tenant() string {
+	return s.tenant
+}
+
+// TenantsHandler serves the landing page listing the tenants.
+func TenantsHandler(servers []*Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := make([]TenantPageData, len(servers))
+		for i, server := range servers {
+			idp := server.requestIDP(r)
+			history := idp.MetadataURL
+			history.Path = server.path("/history")
+			data[i] = TenantPageData{
+				Name:             server.tenant,
+				MetadataURL:      idp.MetadataURL.String(),
+				SSOURL:           idp.SSOURL.String(),
+				HistoryURL:       history.String(),
+				ServiceProviders: len(server.config.ServiceProviders),
+			}
+		}
+		renderTemplate(w, "tenants.html", data)
+	})
+}
+
+// closeServers closes servers created before a later one failed.
+func closeServers(servers []*Server) {
+	for _, server := range servers {
+		if err := server.Close(); err != nil {
+			slog.Error("Error closing IDP server", "tenant", server.tenant, "err", err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package idp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+)
+
+// tenantServers creates servers for tenants at the given base URLs, with
+// routes and the landing page registered on a mux.
+func tenantServers(t *testing.T, baseURLs map[string]string) ([]*Server, *http.ServeMux) {
+	t.Helper()
+
+	cfg := &config.Config{}
+	for _, name := range []string{"acme", "globex"} {
+		cfg.Tenants = append(cfg.Tenants, config.Tenant{
+			Name:    name,
+			BaseURL: baseURLs[name],
+			IDP: config.IDPConfig{
+				CertificatePath: "../../testdata/test.crt",
+				PrivateKeyPath:  "../../testdata/test.key",
+			},
+			ServiceProviders: []config.ServiceProvider{{
+				EntityID: "https://" + name + ".example.com",
+				ACSURL:   "https://" + name + ".example.com/acs",
+				Users:    []config.User{{Name: name + " user", NameID: "user@" + name + ".example.com"}},
+			}},
+		})
+	}
+
+	servers, err := NewTenants(cfg)
+	if err != nil {
+		t.Fatalf("NewTenants failed: %v", err)
+	}
+	t.Cleanup(func() { closeServers(servers) })
+
+	mux := http.NewServeMux()
+	for _, server := range servers {
+		server.RegisterRoutes(mux)
+	}
+	mux.Handle("/{$}", TenantsHandler(servers))
+	return servers, mux
+}
+
+func TestTenantsByPath(t *testing.T) {
+	servers, mux := tenantServers(t, map[string]string{
+		"acme":   "http://localhost:8080/acme",
+		"globex": "http://localhost:8080/globex",
+	})
+	if len(servers) != 2 || servers[0].Tenant() != "acme" || servers[1].Tenant() != "globex" {
+		t.Fatalf("Unexpected tenants: %v", servers)
+	}
+
+	for _, name := range []string{"acme", "globex"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/"+name+"/metadata", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s metadata, got %d", name, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), `entityID="http://localhost:8080/`+name+`/metadata"`) {
+			t.Errorf("Expected %s entity ID in metadata: %s", name, w.Body.String())
+		}
+	}
+
+	// Each tenant only knows its own service providers
+	if servers[0].spProvider.GetServiceProviderConfig("https://globex.example.com") != nil {
+		t.Error("Expected acme not to know globex's service provider")
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	for _, want := range []string{"acme", "http://localhost:8080/acme/metadata", "http://localhost:8080/globex/sso", "http://localhost:8080/globex/history"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q on the landing page: %s", want, body)
+		}
+	}
+}
+
+func TestTenantEntityID(t *testing.T) {
+	cfg := &config.Config{
+		Tenants: []config.Tenant{{
+			Name:    "acme",
+			BaseURL: "http://localhost:8080/acme",
+			IDP: config.IDPConfig{
+				EntityID:        "urn:acme:idp",
+				CertificatePath: "../../testdata/test.crt",
+				PrivateKeyPath:  "../../testdata/test.key",
+			},
+			ServiceProviders: []config.ServiceProvider{{
+				EntityID: "https://sp.example.com",
+				ACSURL:   "https://sp.example.com/acs",
+				Users:    []config.User{{Name: "Test User", NameID: "test@example.com"}},
+			}},
+		}},
+	}
+	servers, err := NewTenants(cfg)
+	if err != nil {
+		t.Fatalf("NewTenants failed: %v", err)
+	}
+	t.Cleanup(func() { closeServers(servers) })
+	server := servers[0]
+
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/acme/metadata", nil))
+	if !strings.Contains(w.Body.String(), `entityID="urn:acme:idp"`) {
+		t.Errorf("Expected the configured entity ID in metadata: %s", w.Body.String())
+	}
+
+	// The assertion is issued and qualified by the configured entity ID
+	requestID := startSSO(t, server)
+	w = postForm(server, server.handleLogin, "/acme/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	assertion := verifyResponse(t, server, decodeSAMLResponse(t, w.Body.String()))
+	if assertion.Issuer.Value != "urn:acme:idp" || assertion.Subject.NameID.NameQualifier != "urn:acme:idp" {
+		t.Errorf("Expected issuer urn:acme:idp, got %s (qualifier %s)", assertion.Issuer.Value, assertion.Subject.NameID.NameQualifier)
+	}
+}
+
+func TestTenantsByHost(t *testing.T) {
+	_, mux := tenantServers(t, map[string]string{
+		"acme":   "http://acme.localhost:8080",
+		"globex": "http://globex.localhost:8080",
+	})
+
+	for _, host := range []string{"acme.localhost:8080", "globex.localhost:8080"} {
+		req := httptest.NewRequest("GET", "/metadata", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if !strings.Contains(w.Body.String(), `entityID="http://`+host+`/metadata"`) {
+			t.Errorf("Expected metadata for %s, got %d: %s", host, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/metadata", nil)
+	req.Host = "localhost:8080"
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown host, got %d", w.Code)
+	}
+}
+
+func TestNewTenantsError(t *testing.T) {
+	cfg := &config.Config{Tenants: []config.Tenant{{
+		Name:    "broken",
+		BaseURL: "http://localhost:8080/broken",
+		IDP:     config.IDPConfig{CertificatePath: "missing.crt", PrivateKeyPath: "missing.key"},
+	}}}
+
+	if _, err := NewTenants(cfg); err == nil || !strings.Contains(err.Error(), "tenant broken") {
+		t.Errorf("Expected error naming the tenant, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package idp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// Validate checks what config.Validate can't: each IdP's certificate and
+// key, and the settings the IDP interprets, such as attribute profiles,
+// NameID formats and SP metadata files. It returns every problem found.
+func Validate(cfg *config.Config, now time.Time) []config.Problem {
+	problems := cfg.CheckKeys(now)
+	for _, tenantCfg := range cfg.TenantConfigs() {
+		problems = append(problems, validateServiceProviders(tenantCfg)...)
+	}
+	return problems
+}
+
+// validateServiceProviders checks each SP's settings, reporting each problem
+// at the setting's path. Checks config.Validate makes, such as requiring an
+// acs_url or metadata_file, are left to it.
+func validateServiceProviders(cfg *config.Config) []config.Problem {
+	var problems []config.Problem
+	for i := range cfg.ServiceProviders {
+		sp := &cfg.ServiceProviders[i]
+		path := fmt.Sprintf("service_providers[%d]", i)
+
+		for _, err := range checkServiceProvider(sp) {
+			problems = append(problems, cfg.Problem(path+"."+err.path, "%v", err.err))
+		}
+
+		var metadata *saml.EntityDescriptor
+		if sp.MetadataFile != "" {
+			var err error
+			if metadata, _, err = loadMetadata(sp); err != nil {
+				problems = append(problems, cfg.Problem(path+".metadata_file", "%v", err))
+				continue
+			}
+		}
+		if sp.AttributeRelease != nil && sp.AttributeRelease.RequestedOnly && !requestsAttributes(metadata) {
+			problems = append(problems, cfg.Warning(path+".attribute_release.requested_only", "no attributes will be released, as the SP metadata requests none"))
+		}
+	}
+	return problems
+}
+
+// requestsAttributes reports whether SP metadata has an
+// AttributeConsumingService with RequestedAttributes.
+func requestsAttributes(metadata *saml.EntityDescriptor) bool {
+	if metadata == nil {
+		return false
+	}
+	for _, descriptor := range metadata.SPSSODescriptors {
+		for _, service := range descriptor.AttributeConsumingServices {
+			if len(service.RequestedAttributes) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
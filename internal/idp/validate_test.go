@@ -0,0 +1,129 @@
+package idp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"test.crt", "test.key"} {
+		data, err := os.ReadFile(filepath.Join("../../testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configPath := filepath.Join(dir, "config.yaml")
+	content := `idp:
+  certificate_path: test.crt
+  private_key_path: test.key
+service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    attribute_profile: "okta"
+    users:
+      - name: "Valid"
+        name_id: "valid@example.com"
+      - name: "Invalid"
+        name_id: "invalid@example.com"
+        name_id_format: "emial"
+  - entity_id: "https://other.example.com"
+    acs_url: "https://other.example.com/acs"
+    attribute_profile: "nope"
+    metadata_file: "missing.xml"
+    users:
+      - name: "Templated"
+        name_id: "templated@example.com"
+        name_ids:
+          emial: "templated@example.com"
+        attributes:
+          department: "{{ .User.Name"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	cert, _ := cfg.IDP.LoadCertificate()
+	problems := Validate(cfg, cert.NotBefore.Add(time.Hour))
+	want := []struct {
+		path string
+		line int
+	}{
+		{"service_providers[0].users[1].name_id_format", 13},
+		{"service_providers[1].attribute_profile", 16},
+		{"service_providers[1].users[0].name_ids.emial", 22},
+		{"service_providers[1].users[0].attributes.department", 24},
+		{"service_providers[1].metadata_file", 17},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %v", len(want), problems)
+	}
+	for i, w := range want {
+		if p := problems[i]; p.Path != w.path || p.Line != w.line {
+			t.Errorf("Expected %s at line %d, got %s", w.path, w.line, p)
+		}
+	}
+
+	// An expired certificate is only a warning
+	problems = Validate(cfg, cert.NotAfter.Add(time.Hour))
+	if len(problems) != len(want)+1 || !problems[0].Warning || problems[0].Path != "idp" {
+		t.Errorf("Expected a certificate warning first, got %v", problems)
+	}
+}
+
+func TestValidateRequestedOnly(t *testing.T) {
+	dir := t.TempDir()
+	metadata := `<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://requesting.example.com">
+  <md:SPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://requesting.example.com/acs" index="1"/>
+    <md:AttributeConsumingService index="1">
+      <md:ServiceName xml:lang="en">App</md:ServiceName>
+      <md:RequestedAttribute Name="email"/>
+    </md:AttributeConsumingService>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>
+`
+	if err := os.WriteFile(filepath.Join(dir, "sp.xml"), []byte(metadata), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(dir, "config.yaml")
+	content := `service_providers:
+  - entity_id: "https://sp.example.com"
+    acs_url: "https://sp.example.com/acs"
+    attribute_release:
+      requested_only: true
+  - entity_id: "https://requesting.example.com"
+    metadata_file: "sp.xml"
+    attribute_release:
+      requested_only: true
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	problems := validateServiceProviders(cfg)
+	if len(problems) != 1 {
+		t.Fatalf("Expected 1 problem, got %v", problems)
+	}
+	if p := problems[0]; !p.Warning || p.Path != "service_providers[0].attribute_release.requested_only" || p.Line != 5 {
+		t.Errorf("Expected a requested_only warning at line 5, got %s", p)
+	}
+}
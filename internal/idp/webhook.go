@@ -0,0 +1,216 @@
+package idp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// Webhook request headers.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// defaultWebhookBackoff is the delay before the first retry; it doubles
+// after each failed attempt.
+const defaultWebhookBackoff = time.Second
+
+// WebhookEvent is the JSON payload posted to webhooks.
+type WebhookEvent struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Tenant    string    `json:"tenant,omitempty"`
+	SP        string    `json:"sp,omitempty"`
+	User      string    `json:"user,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Outcome is "success", the SAML status code sent to the SP or
+	// "invalid_request" when no response could be sent.
+	Outcome string `json:"outcome"`
+	Message string `json:"message,omitempty"`
+}
+
+// Webhooks delivers authentication events to the configured webhooks in
+// the background, retrying failed deliveries with exponential backoff.
+type Webhooks struct {
+	hooks   []config.Webhook
+	client  *http.Client
+	backoff time.Duration
+	wg      sync.WaitGroup
+
+	// ctx is cancelled at shutdown to abandon pending deliveries, which
+	// are counted in dropped
+	ctx     context.Context
+	cancel  context.CancelFunc
+	dropped atomic.Int64
+}
+
+// NewWebhooks creates a notifier for the configured webhooks.
+func NewWebhooks(hooks []config.Webhook) *Webhooks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Webhooks{
+		hooks:   hooks,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: defaultWebhookBackoff,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Notify sends an event to every webhook subscribed to it without waiting
+// for delivery.
+func (wh *Webhooks) Notify(event WebhookEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	var body []byte
+	for i := range wh.hooks {
+		hook := &wh.hooks[i]
+		if !hook.Wants(event.Event) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(event); err != nil {
+				slog.Error("Error encoding webhook event", "err", err)
+				return
+			}
+		}
+
+		wh.wg.Add(1)
+		go func() {
+			defer wh.wg.Done()
+			wh.deliver(hook, event.Event, body)
+		}()
+	}
+}
+
+// Wait blocks until all pending deliveries have finished.
+func (wh *Webhooks) Wait() {
+	wh.wg.Wait()
+}
+
+// Shutdown waits for pending deliveries until ctx is done, then abandons
+// the rest, including their retries, and logs how many were dropped.
+func (wh *Webhooks) Shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		wh.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	wh.cancel()
+	<-done
+
+	if n := wh.dropped.Load(); n > 0 {
+		slog.Warn("Dropped pending webhook deliveries at shutdown", "count", n)
+	}
+}
+
+// deliver posts the body to a webhook, retrying until it's accepted, the
+// webhook's retries are exhausted or the notifier is shut down.
+func (wh *Webhooks) deliver(hook *config.Webhook, event string, body []byte) {
+	delay := wh.backoff
+	for attempt := 0; ; attempt++ {
+		err := wh.post(hook, event, body)
+		if err == nil {
+			return
+		}
+		if wh.ctx.Err() != nil {
+			wh.dropped.Add(1)
+			return
+		}
+		if attempt >= hook.Retries() {
+			slog.Warn("Webhook delivery failed", "url", hook.URL, "event", event, "attempts", attempt+1, "err", err)
+			return
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-wh.ctx.Done():
+			timer.Stop()
+			wh.dropped.Add(1)
+			return
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt. Any 2xx response is a success.
+func (wh *Webhooks) post(hook *config.Webhook, event string, body []byte) error {
+	req, err := http.NewRequestWithContext(wh.ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event)
+	if hook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+webhookSignature(hook.Secret, body))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSignature returns the hex HMAC-SHA256 of the body.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyResponse sends a login event for a response issued to an SP.
+func (s *Server) notifyResponse(event string, req *saml.IdpAuthnRequest, user, outcome, message string) {
+	s.webhooks.Notify(WebhookEvent{
+		Event:     event,
+		Tenant:    s.tenant,
+		SP:        req.ServiceProviderMetadata.EntityID,
+		User:      user,
+		RequestID: req.Request.ID,
+		Outcome:   outcome,
+		Message:   message,
+	})
+}
+
+// notifySSOError sends an sso.error event for an AuthnRequest that was
+// rejected. req may be nil if the request couldn't be parsed.
+func (s *Server) notifySSOError(req *saml.IdpAuthnRequest, outcome, message string) {
+	event := WebhookEvent{
+		Event:   config.WebhookEventSSOError,
+		Tenant:  s.tenant,
+		Outcome: outcome,
+		Message: message,
+	}
+	if req != nil {
+		event.RequestID = req.Request.ID
+		if req.Request.Issuer != nil {
+			event.SP = req.Request.Issuer.Value
+		}
+	}
+	s.webhooks.Notify(event)
+}
@@ -0,0 +1,168 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/breakroom/saml-test-idp/internal/config"
+	"github.com/crewjam/saml"
+)
+
+// webhookReceiver records the deliveries made to a test webhook endpoint,
+// rejecting the first failures attempts.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	events   []WebhookEvent
+	// signed records whether each event carried a valid signature.
+	signed []bool
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	rcv.attempts++
+	if rcv.attempts <= rcv.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rcv.events = append(rcv.events, event)
+	rcv.signed = append(rcv.signed, r.Header.Get(WebhookSignatureHeader) == "sha256="+webhookSignature("s3cret", body))
+}
+
+// webhookServer returns a test server whose webhook posts to rcv.
+func webhookServer(t *testing.T, rcv *webhookReceiver, events ...string) *Server {
+	t.Helper()
+
+	endpoint := httptest.NewServer(rcv)
+	t.Cleanup(endpoint.Close)
+
+	server := testServer(t)
+	server.webhooks = NewWebhooks([]config.Webhook{{URL: endpoint.URL, Secret: "s3cret", Events: events}})
+	server.webhooks.backoff = time.Millisecond
+	return server
+}
+
+func TestWebhookLoginSuccess(t *testing.T) {
+	rcv := &webhookReceiver{}
+	server := webhookServer(t, rcv)
+
+	requestID := startSSO(t, server)
+	postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	server.webhooks.Wait()
+
+	if len(rcv.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(rcv.events))
+	}
+	event := rcv.events[0]
+	if event.Event != config.WebhookEventLoginSuccess || event.Outcome != "success" {
+		t.Errorf("Expected login.success event, got %+v", event)
+	}
+	if event.SP != "https://sp.example.com" || event.User != "Test User" || event.RequestID != "id-test-request" {
+		t.Errorf("Unexpected event details: %+v", event)
+	}
+	if !rcv.signed[0] {
+		t.Error("Expected a valid signature")
+	}
+}
+
+func TestWebhookSSOError(t *testing.T) {
+	rcv := &webhookReceiver{}
+	server := webhookServer(t, rcv)
+
+	postAuthnRequest(server, `<samlp:NameIDPolicy Format="urn:example:unsupported"/>`)
+	server.webhooks.Wait()
+
+	if len(rcv.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(rcv.events))
+	}
+	event := rcv.events[0]
+	if event.Event != config.WebhookEventSSOError || event.Outcome != saml.StatusInvalidNameIDPolicy {
+		t.Errorf("Expected sso.error event with InvalidNameIDPolicy, got %+v", event)
+	}
+	if event.SP != "https://sp.example.com" {
+		t.Errorf("Expected SP entity ID, got %q", event.SP)
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	rcv := &webhookReceiver{}
+	server := webhookServer(t, rcv, config.WebhookEventLoginFailure)
+
+	requestID := startSSO(t, server)
+	postForm(server, server.handleLogin, "/login?request_id="+requestID, url.Values{"user": {"Test User"}})
+	server.webhooks.Wait()
+
+	if rcv.attempts != 0 {
+		t.Errorf("Expected no deliveries for unsubscribed events, got %d", rcv.attempts)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	rcv := &webhookReceiver{failures: 2}
+	server := webhookServer(t, rcv)
+
+	server.webhooks.Notify(WebhookEvent{Event: config.WebhookEventLoginFailure, Outcome: saml.StatusRequestDenied})
+	server.webhooks.Wait()
+
+	if rcv.attempts != 3 || len(rcv.events) != 1 {
+		t.Errorf("Expected delivery on the third attempt, got %d attempts and %d events", rcv.attempts, len(rcv.events))
+	}
+
+	retries := 1
+	rcv = &webhookReceiver{failures: 5}
+	server = webhookServer(t, rcv)
+	server.webhooks.hooks[0].MaxRetries = &retries
+
+	server.webhooks.Notify(WebhookEvent{Event: config.WebhookEventLoginFailure})
+	server.webhooks.Wait()
+
+	if rcv.attempts != 2 {
+		t.Errorf("Expected 2 attempts with max_retries 1, got %d", rcv.attempts)
+	}
+}
+
+func TestWebhookShutdown(t *testing.T) {
+	// Pending deliveries are waited for
+	rcv := &webhookReceiver{failures: 1}
+	server := webhookServer(t, rcv)
+	server.webhooks.Notify(WebhookEvent{Event: config.WebhookEventLoginSuccess})
+	server.webhooks.Shutdown(context.Background())
+	if len(rcv.events) != 1 {
+		t.Errorf("Expected the pending delivery before shutdown, got %d events", len(rcv.events))
+	}
+
+	// Retries still pending at the deadline are dropped
+	rcv = &webhookReceiver{failures: 100}
+	server = webhookServer(t, rcv)
+	server.webhooks.backoff = time.Hour
+	server.webhooks.Notify(WebhookEvent{Event: config.WebhookEventLoginSuccess})
+	server.webhooks.Notify(WebhookEvent{Event: config.WebhookEventLoginFailure})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	server.webhooks.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected shutdown at the deadline, took %s", elapsed)
+	}
+	if n := server.webhooks.dropped.Load(); n != 2 {
+		t.Errorf("Expected 2 dropped deliveries, got %d", n)
+	}
+}